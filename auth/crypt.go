@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const ENCRYPTION_ALGORITHM = "RSA-OAEP-256+A256GCM"
+
+// Encrypted ACL envelope. The ACL is encrypted with a random AES-256-GCM content key which is
+// in turn encrypted with RSA-OAEP (SHA-256) for each recipient public key.
+type envelope struct {
+	Version    int         `json:"version"`
+	Algorithm  string      `json:"algorithm"`
+	Recipients []recipient `json:"recipients"`
+	Nonce      []byte      `json:"nonce"`
+	Ciphertext []byte      `json:"ciphertext"`
+}
+
+type recipient struct {
+	KeyID string `json:"key-id"`
+	Key   []byte `json:"key"`
+}
+
+// Encrypts the ACL for all the RSA public keys ('<id>.pub') in the recipients directory.
+func Encrypt(acl []byte, dir string) ([]byte, error) {
+	recipients, err := loadRecipients(dir)
+	if err != nil {
+		return nil, err
+	} else if len(recipients) == 0 {
+		return nil, fmt.Errorf("no RSA public keys in recipients directory %s", dir)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	e := envelope{
+		Version:    1,
+		Algorithm:  ENCRYPTION_ALGORITHM,
+		Recipients: []recipient{},
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, acl, nil),
+	}
+
+	for _, pubkey := range recipients {
+		keyID, err := fingerprint(pubkey)
+		if err != nil {
+			return nil, err
+		}
+
+		k, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pubkey, key, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Recipients = append(e.Recipients, recipient{
+			KeyID: keyID,
+			Key:   k,
+		})
+	}
+
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// Decrypts an encrypted ACL with the site RSA private key.
+func Decrypt(b []byte, keyfile string, passphrase string) ([]byte, error) {
+	var e envelope
+
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("invalid encrypted ACL (%w)", err)
+	} else if e.Algorithm != ENCRYPTION_ALGORITHM {
+		return nil, fmt.Errorf("unsupported ACL encryption algorithm '%v'", e.Algorithm)
+	}
+
+	key, err := loadPrivateKey(keyfile, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := fingerprint(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range e.Recipients {
+		if r.KeyID != keyID {
+			continue
+		}
+
+		k, err := rsa.DecryptOAEP(sha256.New(), nil, key, r.Key, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encrypted ACL content key (%w)", err)
+		}
+
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(e.Nonce) != gcm.NonceSize() {
+			return nil, fmt.Errorf("invalid encrypted ACL nonce")
+		}
+
+		acl, err := gcm.Open(nil, e.Nonce, e.Ciphertext, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting ACL (%w)", err)
+		}
+
+		return acl, nil
+	}
+
+	return nil, fmt.Errorf("ACL is not encrypted for key %v", keyID)
+}
+
+func loadRecipients(dir string) ([]*rsa.PublicKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pub"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	keys := []*rsa.PublicKey{}
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".pub")
+		if pubkey, err := loadPublicKey(dir, id); err != nil {
+			return nil, err
+		} else {
+			keys = append(keys, pubkey)
+		}
+	}
+
+	if len(keys) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+func fingerprint(pubkey *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pubkey)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(der)
+
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signature envelope in JWS flattened JSON serialization (RFC 7515) with a detached content digest, i.e.
+// the protected header identifies the signing key by fingerprint and the payload binds the signature to
+// the SHA-256 digest of the signed ACL (or manifest). The signing key is resolved from the key ID rather
+// than the (unsigned) archive uname.
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+type jwsPayload struct {
+	SHA256   string `json:"sha256"`
+	IssuedAt int64  `json:"iat"`
+}
+
+const jwsAlgorithm = "RS256"
+const jwsType = "uhppoted-acl+jws"
+
+// Allowed clock skew between the signing and verifying hosts for the envelope issue time.
+const jwsClockSkew = 5 * time.Minute
+
+// Signs the ACL (or manifest) and returns the signature as a JSON signature envelope.
+func Seal(acl []byte, keyfile string, passphrase string) ([]byte, error) {
+	pubkey, err := publicKey(keyfile, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := fingerprint(pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(acl)
+	header, err := json.Marshal(jwsHeader{
+		Algorithm: jwsAlgorithm,
+		KeyID:     keyID,
+		Type:      jwsType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(jwsPayload{
+		SHA256:   hex.EncodeToString(digest[:]),
+		IssuedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e := jws{
+		Protected: base64.RawURLEncoding.EncodeToString(header),
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+	}
+
+	signature, signedBy, err := sign([]byte(e.Protected+"."+e.Payload), keyfile, passphrase)
+	if err != nil {
+		return nil, err
+	} else if signedBy.N.Cmp(pubkey.N) != 0 || signedBy.E != pubkey.E {
+		return nil, fmt.Errorf("RSA signing key does not match public key")
+	}
+
+	e.Signature = base64.RawURLEncoding.EncodeToString(signature)
+
+	return json.MarshalIndent(e, "", "  ")
+}
+
+func isJWS(signature []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(signature), []byte("{"))
+}
+
+// Verifies a JSON signature envelope against the RSA public key in the keys directory with the
+// envelope key ID, returning the key file name and fingerprint as the signer.
+func verifyJWS(acl []byte, signature []byte, dir string) (*Signer, error) {
+	var e jws
+	var header jwsHeader
+	var payload jwsPayload
+
+	if err := json.Unmarshal(signature, &e); err != nil {
+		return nil, fmt.Errorf("invalid signature envelope (%w)", err)
+	}
+
+	if b, err := base64.RawURLEncoding.DecodeString(e.Protected); err != nil {
+		return nil, fmt.Errorf("invalid signature envelope header (%w)", err)
+	} else if err := json.Unmarshal(b, &header); err != nil {
+		return nil, fmt.Errorf("invalid signature envelope header (%w)", err)
+	}
+
+	if header.Algorithm != jwsAlgorithm {
+		return nil, fmt.Errorf("unsupported signature envelope algorithm '%v'", header.Algorithm)
+	} else if header.Type != jwsType {
+		return nil, fmt.Errorf("unsupported signature envelope type '%v'", header.Type)
+	} else if header.KeyID == "" {
+		return nil, fmt.Errorf("signature envelope does not identify the signing key")
+	}
+
+	name, pubkey, err := findPublicKey(dir, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(e.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature envelope signature (%w)", err)
+	}
+
+	hash := sha256.Sum256([]byte(e.Protected + "." + e.Payload))
+	if err := rsa.VerifyPKCS1v15(pubkey, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, fmt.Errorf("%s: invalid RSA signature (%w)", name, err)
+	}
+
+	if b, err := base64.RawURLEncoding.DecodeString(e.Payload); err != nil {
+		return nil, fmt.Errorf("invalid signature envelope payload (%w)", err)
+	} else if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, fmt.Errorf("invalid signature envelope payload (%w)", err)
+	}
+
+	digest := sha256.Sum256(acl)
+	if payload.SHA256 != hex.EncodeToString(digest[:]) {
+		return nil, fmt.Errorf("%s: SHA-256 digest does not match signature envelope", name)
+	}
+
+	if payload.IssuedAt <= 0 {
+		return nil, fmt.Errorf("%s: signature envelope does not include the issue time", name)
+	} else if issued := time.Unix(payload.IssuedAt, 0); issued.After(time.Now().Add(jwsClockSkew)) {
+		return nil, fmt.Errorf("%s: signature envelope issue time (%v) is in the future", name, issued.UTC().Format(time.RFC3339))
+	}
+
+	return &Signer{Name: name, KeyID: header.KeyID}, nil
+}
+
+// Finds the RSA public key in the keys directory with the key ID (fingerprint). Files that are not
+// PEM encoded RSA public keys (e.g. SSH public keys) are ignored.
+func findPublicKey(dir string, keyID string) (string, *rsa.PublicKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pub"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	sort.Strings(files)
+
+	matched := []string{}
+	var pubkey *rsa.PublicKey
+
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".pub")
+		if key, err := loadPublicKey(dir, id); err == nil {
+			if fp, err := fingerprint(key); err == nil && fp == keyID {
+				matched = append(matched, id)
+				pubkey = key
+			}
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return "", nil, fmt.Errorf("no RSA public key with key ID %v in %v", keyID, dir)
+
+	case 1:
+		return matched[0], pubkey, nil
+
+	default:
+		return "", nil, fmt.Errorf("key ID %v matches multiple RSA public keys (%v)", keyID, strings.Join(matched, ","))
+	}
+}
+
+// Retrieves the RSA public key for a signing key file (or PKCS#11 key). PKCS#11 public keys are only
+// available from the token as part of signing, so the key is retrieved by signing an empty message.
+func publicKey(keyfile string, passphrase string) (*rsa.PublicKey, error) {
+	if strings.HasPrefix(keyfile, "pkcs11:") {
+		_, pubkey, err := signPKCS11([]byte{}, keyfile, passphrase)
+
+		return pubkey, err
+	}
+
+	key, err := loadPrivateKey(keyfile, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key.PublicKey, nil
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// Generates an RSA signing key, returning the PEM encoded PKCS#8 private key (encrypted if a
+// passphrase source is provided) and PKIX public key.
+func GenerateKey(bits int, source string) ([]byte, []byte, error) {
+	if bits < 2048 {
+		return nil, nil, fmt.Errorf("invalid RSA key size (%v) - minimum key size is 2048 bits", bits)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block := pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	}
+
+	if source != "" {
+		secret, err := passphrase(source)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if block.Bytes, err = encryptPKCS8(der, secret); err != nil {
+			return nil, nil, err
+		}
+
+		block.Type = "ENCRYPTED PRIVATE KEY"
+	}
+
+	pubkey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&block), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubkey}), nil
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Retrieves a private key passphrase from the source specified as one of:
+//
+//	file:<path>        passphrase file
+//	env:<variable>     environment variable
+//	credential:<name>  systemd credential (i.e. $CREDENTIALS_DIRECTORY/<name>)
+func passphrase(source string) ([]byte, error) {
+	scheme, value, ok := strings.Cut(source, ":")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("invalid passphrase source '%v'", source)
+	}
+
+	switch scheme {
+	case "file":
+		return readPassphrase(value)
+
+	case "env":
+		if v, ok := os.LookupEnv(value); !ok {
+			return nil, fmt.Errorf("passphrase environment variable %v not set", value)
+		} else {
+			return []byte(v), nil
+		}
+
+	case "credential":
+		dir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if dir == "" {
+			return nil, fmt.Errorf("no systemd credentials directory for passphrase credential '%v'", value)
+		} else if filepath.Base(value) != value {
+			return nil, fmt.Errorf("invalid passphrase credential '%v'", value)
+		}
+
+		return readPassphrase(filepath.Join(dir, value))
+
+	default:
+		return nil, fmt.Errorf("invalid passphrase source '%v'", source)
+	}
+}
+
+func readPassphrase(file string) ([]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(b, "\r\n"), nil
+}
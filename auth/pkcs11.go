@@ -0,0 +1,227 @@
+//go:build pkcs11
+
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+)
+
+type pkcs11URI struct {
+	module string
+	slot   *uint
+	token  string
+	label  string
+	id     []byte
+}
+
+// Signs the ACL with an RSA private key held in a PKCS#11 token. The key is identified by an
+// RFC 7512 PKCS#11 URI e.g.
+//
+//	pkcs11:slot-id=0;object=uhppoted?module-path=/usr/lib/softhsm/libsofthsm2.so
+//
+// The (optional) token user PIN is retrieved from the passphrase source. Returns the signature and
+// the RSA public key (from the private key modulus and public exponent).
+func signPKCS11(acl []byte, uri string, source string) ([]byte, *rsa.PublicKey, error) {
+	k, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pin := ""
+	if source != "" {
+		if secret, err := passphrase(source); err != nil {
+			return nil, nil, err
+		} else {
+			pin = string(secret)
+		}
+	}
+
+	p := pkcs11.New(k.module)
+	if p == nil {
+		return nil, nil, fmt.Errorf("error loading PKCS#11 module %v", k.module)
+	}
+
+	defer p.Destroy()
+
+	if err := p.Initialize(); err != nil {
+		return nil, nil, fmt.Errorf("error initialising PKCS#11 module %v (%w)", k.module, err)
+	}
+
+	defer p.Finalize()
+
+	slot, err := findSlot(p, k)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := p.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening PKCS#11 session (%w)", err)
+	}
+
+	defer p.CloseSession(session)
+
+	if pin != "" {
+		if err := p.Login(session, pkcs11.CKU_USER, pin); err != nil {
+			return nil, nil, fmt.Errorf("PKCS#11 login failed (%w)", err)
+		}
+
+		defer p.Logout(session)
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+	}
+
+	if k.label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, k.label))
+	}
+
+	if k.id != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, k.id))
+	}
+
+	if err := p.FindObjectsInit(session, template); err != nil {
+		return nil, nil, err
+	}
+
+	objects, _, err := p.FindObjects(session, 2)
+	if err != nil {
+		p.FindObjectsFinal(session)
+		return nil, nil, err
+	} else if err := p.FindObjectsFinal(session); err != nil {
+		return nil, nil, err
+	}
+
+	if len(objects) == 0 {
+		return nil, nil, fmt.Errorf("no matching RSA private key in PKCS#11 token (%v)", uri)
+	} else if len(objects) > 1 {
+		return nil, nil, fmt.Errorf("multiple matching RSA private keys in PKCS#11 token (%v)", uri)
+	}
+
+	attributes, err := p.GetAttributeValue(session, objects[0], []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error retrieving PKCS#11 RSA public key (%w)", err)
+	}
+
+	pubkey := rsa.PublicKey{}
+	for _, a := range attributes {
+		switch a.Type {
+		case pkcs11.CKA_MODULUS:
+			pubkey.N = new(big.Int).SetBytes(a.Value)
+		case pkcs11.CKA_PUBLIC_EXPONENT:
+			pubkey.E = int(new(big.Int).SetBytes(a.Value).Int64())
+		}
+	}
+
+	if pubkey.N == nil || pubkey.E == 0 {
+		return nil, nil, fmt.Errorf("invalid PKCS#11 RSA public key (%v)", uri)
+	}
+
+	mechanism := []*pkcs11.Mechanism{
+		pkcs11.NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS, nil),
+	}
+
+	if err := p.SignInit(session, mechanism, objects[0]); err != nil {
+		return nil, nil, fmt.Errorf("PKCS#11 sign failed (%w)", err)
+	}
+
+	signature, err := p.Sign(session, acl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("PKCS#11 sign failed (%w)", err)
+	}
+
+	return signature, &pubkey, nil
+}
+
+func findSlot(p *pkcs11.Ctx, k *pkcs11URI) (uint, error) {
+	if k.slot != nil && k.token == "" {
+		return *k.slot, nil
+	}
+
+	slots, err := p.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, slot := range slots {
+		if k.slot != nil && slot != *k.slot {
+			continue
+		}
+
+		if k.token == "" {
+			return slot, nil
+		}
+
+		if info, err := p.GetTokenInfo(slot); err != nil {
+			return 0, err
+		} else if strings.TrimSpace(info.Label) == k.token {
+			return slot, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no matching PKCS#11 token")
+}
+
+func parsePKCS11URI(uri string) (*pkcs11URI, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(uri, "pkcs11:"), "?")
+	k := pkcs11URI{}
+
+	for _, attr := range strings.Split(path, ";") {
+		if attr == "" {
+			continue
+		}
+
+		name, v, _ := strings.Cut(attr, "=")
+		value, err := url.PathUnescape(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PKCS#11 URI attribute '%v' (%w)", attr, err)
+		}
+
+		switch name {
+		case "token":
+			k.token = value
+
+		case "object":
+			k.label = value
+
+		case "id":
+			k.id = []byte(value)
+
+		case "slot-id":
+			if slot, err := strconv.ParseUint(value, 10, 0); err != nil {
+				return nil, fmt.Errorf("invalid PKCS#11 URI slot-id '%v'", value)
+			} else {
+				s := uint(slot)
+				k.slot = &s
+			}
+		}
+	}
+
+	if values, err := url.ParseQuery(query); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#11 URI '%v' (%w)", uri, err)
+	} else {
+		k.module = values.Get("module-path")
+	}
+
+	if k.module == "" {
+		return nil, fmt.Errorf("PKCS#11 URI '%v' does not specify a module-path", uri)
+	}
+
+	if k.label == "" && k.id == nil {
+		return nil, fmt.Errorf("PKCS#11 URI '%v' does not specify a key object or id", uri)
+	}
+
+	return &k, nil
+}
@@ -0,0 +1,12 @@
+//go:build !pkcs11
+
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+)
+
+func signPKCS11(acl []byte, uri string, source string) ([]byte, *rsa.PublicKey, error) {
+	return nil, nil, fmt.Errorf("PKCS#11 signing keys are not supported by this build (rebuild with '-tags pkcs11')")
+}
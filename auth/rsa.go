@@ -10,51 +10,98 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-func Sign(acl []byte, keyfile string) ([]byte, error) {
-	key, err := loadPrivateKey(keyfile)
-	if err != nil {
-		return nil, err
-	} else if key == nil {
-		return nil, fmt.Errorf("invalid RSA signing key")
-	}
+// Identifies the key that signed an ACL (or manifest) by the key file name (or allowed_signers principal)
+// and the key fingerprint.
+type Signer struct {
+	Name  string
+	KeyID string
+}
 
-	rng := rand.Reader
-	hashed := sha256.Sum256(acl)
+func (s Signer) String() string {
+	return fmt.Sprintf("%v (%v)", s.Name, s.KeyID)
+}
+
+func Sign(acl []byte, keyfile string, passphrase string) ([]byte, error) {
+	signature, _, err := sign(acl, keyfile, passphrase)
 
-	return rsa.SignPKCS1v15(rng, key, crypto.SHA256, hashed[:])
+	return signature, err
 }
 
-func Verify(signedBy string, acl []byte, signature []byte, dir string) error {
+func Verify(signedBy string, acl []byte, signature []byte, dir string) (*Signer, error) {
+	if isJWS(signature) {
+		return verifyJWS(acl, signature, dir)
+	}
+
+	if isSSHSignature(signature) {
+		return verifySSH(signedBy, acl, signature, dir)
+	}
+
 	pubkey, err := loadPublicKey(dir, signedBy)
 	if err != nil {
-		return err
+		return nil, err
 	} else if pubkey == nil {
-		return fmt.Errorf("%s: no RSA public key", signedBy)
+		return nil, fmt.Errorf("%s: no RSA public key", signedBy)
 	}
 
 	hash := sha256.Sum256(acl)
 	err = rsa.VerifyPKCS1v15(pubkey, crypto.SHA256, hash[:], signature)
 	if err != nil {
-		return fmt.Errorf("%s: invalid RSA signature (%w)", signedBy, err)
+		return nil, fmt.Errorf("%s: invalid RSA signature (%w)", signedBy, err)
+	}
+
+	keyID, err := fingerprint(pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{Name: signedBy, KeyID: keyID}, nil
+}
+
+func sign(acl []byte, keyfile string, passphrase string) ([]byte, *rsa.PublicKey, error) {
+	if strings.HasPrefix(keyfile, "pkcs11:") {
+		return signPKCS11(acl, keyfile, passphrase)
+	}
+
+	key, err := loadPrivateKey(keyfile, passphrase)
+	if err != nil {
+		return nil, nil, err
+	} else if key == nil {
+		return nil, nil, fmt.Errorf("invalid RSA signing key")
+	}
+
+	rng := rand.Reader
+	hashed := sha256.Sum256(acl)
+
+	signature, err := rsa.SignPKCS1v15(rng, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return signature, &key.PublicKey, nil
 }
 
-func loadPrivateKey(filepath string) (*rsa.PrivateKey, error) {
+func loadPrivateKey(filepath string, passphrase string) (*rsa.PrivateKey, error) {
 	bytes, err := os.ReadFile(filepath)
 	if err != nil {
 		return nil, err
 	}
 
 	block, _ := pem.Decode(bytes)
-	if block == nil || block.Type != "PRIVATE KEY" {
+	if block == nil || (block.Type != "PRIVATE KEY" && block.Type != "ENCRYPTED PRIVATE KEY") {
 		return nil, fmt.Errorf("%s is not a valid RSA private key", filepath)
 	}
 
-	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	der := block.Bytes
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		if der, err = decryptPrivateKey(der, passphrase); err != nil {
+			return nil, fmt.Errorf("%s: error decrypting RSA private key (%w)", filepath, err)
+		}
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
 	if err != nil {
 		return nil, fmt.Errorf("%s is not a valid RSA private key", filepath)
 	}
@@ -67,6 +114,19 @@ func loadPrivateKey(filepath string) (*rsa.PrivateKey, error) {
 	return pk, nil
 }
 
+func decryptPrivateKey(der []byte, source string) ([]byte, error) {
+	if source == "" {
+		return nil, fmt.Errorf("passphrase required")
+	}
+
+	secret, err := passphrase(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptPKCS8(der, secret)
+}
+
 func loadPublicKey(dir, id string) (*rsa.PublicKey, error) {
 	file := filepath.Join(dir, id+".pub")
 	bytes, err := os.ReadFile(file)
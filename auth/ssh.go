@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const SSH_NAMESPACE = "uhppoted-acl"
+const ALLOWED_SIGNERS = "allowed_signers"
+
+const sshsigMagic = "SSHSIG"
+const sshsigVersion = 1
+
+type sshsig struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	Hash      string
+	Signature []byte
+}
+
+type sshsigSignedData struct {
+	Namespace string
+	Reserved  string
+	Hash      string
+	Digest    []byte
+}
+
+type allowedSigner struct {
+	principals  []string
+	namespaces  []string
+	validAfter  *time.Time
+	validBefore *time.Time
+	key         ssh.PublicKey
+}
+
+func isSSHSignature(signature []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN SSH SIGNATURE-----"))
+}
+
+// Verifies an 'ssh-keygen -Y sign -n uhppoted-acl' signature against the keys listed for the
+// signer in the 'allowed_signers' file in the keys directory.
+func verifySSH(signedBy string, acl []byte, signature []byte, dir string) (*Signer, error) {
+	sig, err := parseSSHSignature(signature)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid SSH signature (%w)", signedBy, err)
+	}
+
+	if sig.Namespace != SSH_NAMESPACE {
+		return nil, fmt.Errorf("%s: invalid SSH signature namespace '%s'", signedBy, sig.Namespace)
+	}
+
+	pubkey, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid SSH signature public key (%w)", signedBy, err)
+	}
+
+	signers, err := loadAllowedSigners(filepath.Join(dir, ALLOWED_SIGNERS))
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAllowedSigner(signers, signedBy, pubkey, time.Now()) {
+		return nil, fmt.Errorf("%s: no matching SSH public key in %s", signedBy, ALLOWED_SIGNERS)
+	}
+
+	var digest []byte
+	switch sig.Hash {
+	case "sha256":
+		h := sha256.Sum256(acl)
+		digest = h[:]
+
+	case "sha512":
+		h := sha512.Sum512(acl)
+		digest = h[:]
+
+	default:
+		return nil, fmt.Errorf("%s: unsupported SSH signature hash algorithm '%s'", signedBy, sig.Hash)
+	}
+
+	var s ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &s); err != nil {
+		return nil, fmt.Errorf("%s: invalid SSH signature (%w)", signedBy, err)
+	} else if s.Format == ssh.KeyAlgoRSA {
+		return nil, fmt.Errorf("%s: SSH signature uses deprecated SHA-1 algorithm", signedBy)
+	}
+
+	signed := append([]byte(sshsigMagic), ssh.Marshal(sshsigSignedData{
+		Namespace: sig.Namespace,
+		Reserved:  sig.Reserved,
+		Hash:      sig.Hash,
+		Digest:    digest,
+	})...)
+
+	if err := pubkey.Verify(signed, &s); err != nil {
+		return nil, fmt.Errorf("%s: invalid SSH signature (%w)", signedBy, err)
+	}
+
+	return &Signer{Name: signedBy, KeyID: ssh.FingerprintSHA256(pubkey)}, nil
+}
+
+func parseSSHSignature(signature []byte) (*sshsig, error) {
+	block, _ := pem.Decode(signature)
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return nil, fmt.Errorf("invalid armoured signature")
+	}
+
+	if !bytes.HasPrefix(block.Bytes, []byte(sshsigMagic)) {
+		return nil, fmt.Errorf("missing %v preamble", sshsigMagic)
+	}
+
+	var sig sshsig
+	if err := ssh.Unmarshal(block.Bytes[len(sshsigMagic):], &sig); err != nil {
+		return nil, err
+	} else if sig.Version != sshsigVersion {
+		return nil, fmt.Errorf("unsupported signature version (%v)", sig.Version)
+	}
+
+	return &sig, nil
+}
+
+// Parses an ssh-keygen ALLOWED SIGNERS file i.e. lines formatted as:
+//
+//	principals [options] keytype base64-key [comment]
+//
+// The 'namespaces', 'valid-after' and 'valid-before' options are supported. Certificate authority
+// entries are ignored.
+func loadAllowedSigners(file string) ([]allowedSigner, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	signers := []allowedSigner{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		principals, rest := splitPrincipals(text)
+		if principals == "" || rest == "" {
+			return nil, fmt.Errorf("%s: invalid entry at line %d", file, line)
+		}
+
+		key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(rest))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid entry at line %d (%w)", file, line, err)
+		}
+
+		signer := allowedSigner{
+			principals: strings.Split(principals, ","),
+			key:        key,
+		}
+
+		ca := false
+		for _, option := range options {
+			k, v, _ := strings.Cut(option, "=")
+			v = strings.Trim(v, `"`)
+
+			switch strings.ToLower(k) {
+			case "cert-authority":
+				ca = true
+
+			case "namespaces":
+				signer.namespaces = strings.Split(v, ",")
+
+			case "valid-after":
+				if t, err := parseSSHTime(v); err != nil {
+					return nil, fmt.Errorf("%s: invalid 'valid-after' at line %d (%w)", file, line, err)
+				} else {
+					signer.validAfter = &t
+				}
+
+			case "valid-before":
+				if t, err := parseSSHTime(v); err != nil {
+					return nil, fmt.Errorf("%s: invalid 'valid-before' at line %d (%w)", file, line, err)
+				} else {
+					signer.validBefore = &t
+				}
+			}
+		}
+
+		if !ca {
+			signers = append(signers, signer)
+		}
+	}
+
+	return signers, scanner.Err()
+}
+
+func splitPrincipals(line string) (string, string) {
+	if strings.HasPrefix(line, `"`) {
+		if ix := strings.Index(line[1:], `"`); ix >= 0 {
+			return line[1 : ix+1], strings.TrimSpace(line[ix+2:])
+		}
+
+		return "", ""
+	}
+
+	ix := strings.IndexFunc(line, unicode.IsSpace)
+	if ix < 0 {
+		return line, ""
+	}
+
+	return line[:ix], strings.TrimSpace(line[ix:])
+}
+
+func parseSSHTime(s string) (time.Time, error) {
+	location := time.Local
+	if strings.HasSuffix(s, "Z") {
+		location = time.UTC
+		s = strings.TrimSuffix(s, "Z")
+	}
+
+	for _, layout := range []string{"20060102150405", "200601021504", "20060102"} {
+		if len(s) == len(layout) {
+			return time.ParseInLocation(layout, s, location)
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time '%s'", s)
+}
+
+func isAllowedSigner(signers []allowedSigner, principal string, key ssh.PublicKey, now time.Time) bool {
+	for _, signer := range signers {
+		if !bytes.Equal(signer.key.Marshal(), key.Marshal()) {
+			continue
+		}
+
+		if !matchPrincipal(signer.principals, principal) {
+			continue
+		}
+
+		if len(signer.namespaces) > 0 && !matchPrincipal(signer.namespaces, SSH_NAMESPACE) {
+			continue
+		}
+
+		if signer.validAfter != nil && now.Before(*signer.validAfter) {
+			continue
+		}
+
+		if signer.validBefore != nil && now.After(*signer.validBefore) {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func matchPrincipal(patterns []string, principal string) bool {
+	matched := false
+
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		if ok, err := path.Match(pattern, principal); err == nil && ok {
+			if negated {
+				return false
+			}
+
+			matched = true
+		}
+	}
+
+	return matched
+}
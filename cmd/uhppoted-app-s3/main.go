@@ -15,6 +15,14 @@ var cli = []uhppoted.Command{
 	&commands.LoadACLCmd,
 	&commands.StoreACLCmd,
 	&commands.CompareACLCmd,
+	&commands.KeyGenCmd,
+	&commands.SignACLCmd,
+	&commands.VerifyACLCmd,
+	&commands.PublishACLCmd,
+	&commands.ValidateACLCmd,
+	&commands.ConvertACLCmd,
+	&commands.DiffACLCmd,
+	&commands.WatchACLCmd,
 	&uhppoted.Version{
 		Application: commands.APP,
 		Version:     uhppote.VERSION,
@@ -5,16 +5,20 @@ import (
 	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strings"
 	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -23,13 +27,46 @@ import (
 	"github.com/uhppoted/uhppote-core/types"
 	"github.com/uhppoted/uhppote-core/uhppote"
 	"github.com/uhppoted/uhppoted-app-s3/auth"
+	"github.com/uhppoted/uhppoted-app-s3/log"
 	"github.com/uhppoted/uhppoted-lib/acl"
 	"github.com/uhppoted/uhppoted-lib/config"
 )
 
+// Signature formats: 'rsa' is a raw RSA signature and 'jws' is a JSON signature envelope that identifies
+// the signing key. The default is 'jws' - a raw RSA signature does not bind the (unsigned) archive uname to the
+// signature and is retained for compatibility with earlier releases.
+const (
+	signatureRSA = "rsa"
+	signatureJWS = "jws"
+)
+
+var errNotFound = errors.New("not found")
+
+type archive struct {
+	name      string
+	acl       []byte
+	encrypted bool
+	delta     bool
+	signature []byte
+	manifest  []byte
+	groups    []byte
+	uname     string
+	entries   map[string][]byte
+	unames    map[string]string
+}
+
+// S3 object or file returned by a prefix listing.
+type stored struct {
+	url      string
+	key      string
+	modified time.Time
+}
+
 type Report struct {
 	DateTime *types.DateTime
+	Signer   *auth.Signer
 	Diffs    map[uint32]acl.Diff
+	Groups   map[string]GroupDiff
 }
 
 func getDevices(conf *config.Config, debug bool) (uhppote.IUHPPOTE, []uhppote.Device) {
@@ -62,6 +99,12 @@ func fetchHTTP(url string) ([]byte, error) {
 
 	defer response.Body.Close()
 
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("GET %v (%w)", response.Status, errNotFound)
+	} else if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("GET %v", response.Status)
+	}
+
 	var b bytes.Buffer
 	if _, err = io.Copy(&b, response.Body); err != nil {
 		return nil, err
@@ -107,6 +150,151 @@ func fetchFile(url string) ([]byte, error) {
 	return os.ReadFile(match[1])
 }
 
+// Returns true if the error returned by fetchHTTP, fetchS3 or fetchFile is because there is no file at
+// the URL.
+func isNotFound(err error) bool {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound") {
+		return true
+	}
+
+	return errors.Is(err, errNotFound) || errors.Is(err, os.ErrNotExist)
+}
+
+// Returns the ETag (or Last-Modified timestamp) of the file at the URL without fetching the file.
+func headHTTP(url string) (string, error) {
+	response, err := http.Head(url)
+	if err != nil {
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HEAD %v: %v", url, response.Status)
+	}
+
+	if etag := response.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+
+	return response.Header.Get("Last-Modified"), nil
+}
+
+func headS3(url, config, profile, region string) (string, error) {
+	match := regexp.MustCompile("^s3://(.*?)/(.*)").FindStringSubmatch(url)
+	if len(match) != 3 {
+		return "", fmt.Errorf("invalid S3 URI (%s)", url)
+	}
+
+	object := s3.HeadObjectInput{
+		Bucket: aws.String(match[1]),
+		Key:    aws.String(match[2]),
+	}
+
+	cfg := aws.NewConfig().
+		WithCredentials(credentials.NewSharedCredentials(config, profile)).
+		WithRegion(region)
+
+	ss := session.Must(session.NewSession(cfg))
+
+	response, err := s3.New(ss).HeadObject(&object)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(response.ETag), nil
+}
+
+func headFile(url string) (string, error) {
+	match := regexp.MustCompile("^file://(.*)").FindStringSubmatch(url)
+	if len(match) != 2 {
+		return "", fmt.Errorf("invalid file URI (%s)", url)
+	}
+
+	info, err := os.Stat(match[1])
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%v:%v", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// Returns the objects in the S3 bucket with keys that start with the URL prefix. The listing is not
+// recursive i.e. objects in 'sub-directories' of the prefix are not included (as for file:// prefixes).
+func listS3(url, config, profile, region string) ([]stored, error) {
+	match := regexp.MustCompile("^s3://(.*?)/(.*)").FindStringSubmatch(url)
+	if len(match) != 3 {
+		return nil, fmt.Errorf("invalid S3 URI (%s)", url)
+	}
+
+	bucket := match[1]
+	request := s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(match[2]),
+		Delimiter: aws.String("/"),
+	}
+
+	cfg := aws.NewConfig().
+		WithCredentials(credentials.NewSharedCredentials(config, profile)).
+		WithRegion(region)
+
+	ss := session.Must(session.NewSession(cfg))
+
+	objects := []stored{}
+	err := s3.New(ss).ListObjectsV2Pages(&request, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			key := aws.StringValue(object.Key)
+			objects = append(objects, stored{
+				url:      fmt.Sprintf("s3://%v/%v", bucket, key),
+				key:      key,
+				modified: aws.TimeValue(object.LastModified),
+			})
+		}
+
+		return true
+	})
+
+	return objects, err
+}
+
+// Returns the files that start with the URL prefix, which is either a directory (if the URL ends
+// with '/') or a directory and file name prefix.
+func listFile(url string) ([]stored, error) {
+	match := regexp.MustCompile("^file://(.*)").FindStringSubmatch(url)
+	if len(match) != 2 {
+		return nil, fmt.Errorf("invalid file URI (%s)", url)
+	}
+
+	dir, prefix := filepath.Split(match[1])
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []stored{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), prefix) {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, stored{
+				url:      "file://" + filepath.Join(dir, entry.Name()),
+				key:      entry.Name(),
+				modified: info.ModTime(),
+			})
+		}
+	}
+
+	return files, nil
+}
+
 func storeHTTP(uri string, r io.Reader) error {
 	rq, err := http.NewRequest("PUT", uri, r)
 	if err != nil {
@@ -122,6 +310,10 @@ func storeHTTP(uri string, r io.Reader) error {
 
 	defer response.Body.Close()
 
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("PUT %v", response.Status)
+	}
+
 	return nil
 }
 
@@ -167,7 +359,7 @@ func storeFile(url string, r io.Reader) error {
 	return os.WriteFile(match[1], b, 0660)
 }
 
-func targz(files map[string][]byte, w io.Writer) error {
+func targz(files map[string][]byte, uname string, w io.Writer) error {
 	var b bytes.Buffer
 
 	tw := tar.NewWriter(&b)
@@ -176,8 +368,8 @@ func targz(files map[string][]byte, w io.Writer) error {
 			Name:  filename,
 			Mode:  0660,
 			Size:  int64(len(body)),
-			Uname: "uhppoted",
-			Gname: "uhppoted",
+			Uname: uname,
+			Gname: uname,
 		}
 
 		if err := tw.WriteHeader(header); err != nil {
@@ -207,13 +399,15 @@ func targz(files map[string][]byte, w io.Writer) error {
 	return gz.Close()
 }
 
-func untar(r io.Reader) (map[string][]byte, string, error) {
-	files := map[string][]byte{}
-	uname := ""
+func untar(r io.Reader) (*archive, error) {
+	a := archive{
+		entries: map[string][]byte{},
+		unames:  map[string]string{},
+	}
 
 	gz, err := gzip.NewReader(r)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
 	tr := tar.NewReader(gz)
@@ -223,55 +417,41 @@ func untar(r io.Reader) (map[string][]byte, string, error) {
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, "", err
+			return nil, err
 		}
 
-		switch header.Typeflag {
-		case tar.TypeReg:
-			if filepath.Ext(header.Name) == ".acl" {
-				if _, ok := files["ACL"]; ok {
-					return nil, "", fmt.Errorf("multiple ACL files in tar.gz")
-				}
-
-				var buffer bytes.Buffer
-				if _, err := io.Copy(&buffer, tr); err != nil {
-					return nil, "", err
-				}
-
-				files["ACL"] = buffer.Bytes()
-				uname = header.Uname
+		if header.Typeflag == tar.TypeReg {
+			var buffer bytes.Buffer
+			if _, err := io.Copy(&buffer, tr); err != nil {
+				return nil, err
 			}
 
-			if header.Name == "signature" {
-				if _, ok := files["signature"]; ok {
-					return nil, "", fmt.Errorf("multiple signature files in tar.gz")
-				}
-
-				var buffer bytes.Buffer
-				if _, err := io.Copy(&buffer, tr); err != nil {
-					return nil, "", err
-				}
-
-				files["signature"] = buffer.Bytes()
+			if err := a.add(header.Name, header.Uname, buffer.Bytes(), "tar.gz"); err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	if _, ok := files["ACL"]; !ok {
-		return nil, "", fmt.Errorf("ACL file missing from tar.gz")
-	}
-
-	if _, ok := files["signature"]; !ok {
-		return nil, "", fmt.Errorf("'signature' file missing from tar.gz")
+	if err := a.validate("tar.gz"); err != nil {
+		return nil, err
 	}
 
-	return files, uname, nil
+	return &a, nil
 }
 
-func zipf(files map[string][]byte, w io.Writer) error {
+func zipf(files map[string][]byte, uname string, w io.Writer) error {
 	zw := zip.NewWriter(w)
 	for filename, body := range files {
-		if f, err := zw.Create(filename); err != nil {
+		header := &zip.FileHeader{
+			Name:   filename,
+			Method: zip.Deflate,
+		}
+
+		if isACL(filename) || isDelta(filename) {
+			header.Comment = uname
+		}
+
+		if f, err := zw.CreateHeader(header); err != nil {
 			return err
 		} else if _, err = f.Write([]byte(body)); err != nil {
 			return err
@@ -281,81 +461,179 @@ func zipf(files map[string][]byte, w io.Writer) error {
 	return zw.Close()
 }
 
-func unzip(r io.Reader) (map[string][]byte, string, error) {
-	files := map[string][]byte{}
-	uname := ""
+func unzip(r io.Reader) (*archive, error) {
+	a := archive{
+		entries: map[string][]byte{},
+		unames:  map[string]string{},
+	}
 
 	b, err := io.ReadAll(r)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
 	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
 	for _, f := range zr.File {
-		if filepath.Ext(f.Name) == ".acl" {
-			if _, ok := files["ACL"]; ok {
-				return nil, "", fmt.Errorf("multiple ACL files in tar.gz")
-			}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
 
-			rc, err := f.Open()
-			if err != nil {
-				return nil, "", err
-			}
+		var buffer bytes.Buffer
+		_, err = io.Copy(&buffer, rc)
+		rc.Close()
 
-			var buffer bytes.Buffer
-			if _, err := io.Copy(&buffer, rc); err != nil {
-				return nil, "", err
-			}
+		if err != nil {
+			return nil, err
+		}
 
-			files["ACL"] = buffer.Bytes()
-			uname = f.Comment
-			rc.Close()
+		if err := a.add(f.Name, f.Comment, buffer.Bytes(), "zip"); err != nil {
+			return nil, err
 		}
+	}
 
-		if f.Name == "signature" {
-			if _, ok := files["signature"]; ok {
-				return nil, "", fmt.Errorf("multiple signature files in tar.gz")
-			}
+	if err := a.validate("zip"); err != nil {
+		return nil, err
+	}
 
-			rc, err := f.Open()
-			if err != nil {
-				return nil, "", err
-			}
+	return &a, nil
+}
 
-			var buffer bytes.Buffer
-			if _, err := io.Copy(&buffer, rc); err != nil {
-				return nil, "", err
-			}
+func unpack(uri string, b []byte) (*archive, error) {
+	x := untar
+	if strings.HasSuffix(uri, ".zip") {
+		x = unzip
+	}
+
+	return x(bytes.NewReader(b))
+}
 
-			files["signature"] = buffer.Bytes()
-			rc.Close()
+func (a *archive) add(name, uname string, body []byte, format string) error {
+	if _, ok := a.entries[name]; ok {
+		return fmt.Errorf("duplicate file '%v' in %v", name, format)
+	}
+
+	switch {
+	case name == "signature":
+		if a.signature != nil {
+			return fmt.Errorf("multiple signature files in %v", format)
+		}
+
+		a.signature = body
+
+	case name == "manifest":
+		if a.manifest != nil {
+			return fmt.Errorf("multiple manifest files in %v", format)
 		}
+
+		a.manifest = body
+
+	case name == "groups":
+		a.groups = body
 	}
 
-	if _, ok := files["ACL"]; !ok {
-		return nil, "", fmt.Errorf("ACL file missing from tar.gz")
+	a.entries[name] = body
+	a.unames[name] = uname
+
+	return nil
+}
+
+// Identifies the ACL file in the archive. An archive with a manifest is expected to contain the ACL file
+// listed in the manifest (so that e.g. other .json files are not mistaken for the ACL file) and an archive
+// without a manifest is expected to contain a single ACL file.
+func (a *archive) validate(format string) error {
+	names := []string{}
+	for name := range a.entries {
+		if isACL(name) || isDelta(name) {
+			names = append(names, name)
+		}
+	}
+
+	if a.manifest != nil {
+		if m, err := parseManifest(a.manifest); err != nil {
+			return err
+		} else {
+			names = slices.DeleteFunc(names, func(name string) bool {
+				_, ok := m.Files[name]
+				return !ok
+			})
+		}
 	}
 
-	if _, ok := files["signature"]; !ok {
-		return nil, "", fmt.Errorf("'signature' file missing from tar.gz")
+	switch len(names) {
+	case 0:
+		return fmt.Errorf("ACL file missing from %v", format)
+
+	case 1:
+		a.name = names[0]
+		a.acl = a.entries[a.name]
+		a.encrypted = filepath.Ext(a.name) == ".enc"
+		a.delta = isDelta(a.name)
+		a.uname = a.unames[a.name]
+
+	default:
+		slices.Sort(names)
+		return fmt.Errorf("multiple ACL files in %v (%v)", format, strings.Join(names, ", "))
 	}
 
-	return files, uname, nil
+	if a.signature == nil {
+		return fmt.Errorf("'signature' file missing from %v", format)
+	}
+
+	return nil
 }
 
-func sign(acl []byte, keyfile string) ([]byte, error) {
-	return auth.Sign(acl, keyfile)
+// Signs the ACL (or manifest or report) with either a raw RSA signature or a JSON signature envelope.
+func sign(acl []byte, keyfile string, passphrase string, format string) ([]byte, error) {
+	if format == signatureJWS {
+		return auth.Seal(acl, keyfile, passphrase)
+	}
+
+	return auth.Sign(acl, keyfile, passphrase)
+}
+
+func checkSignatureFormat(format string) error {
+	if format != signatureRSA && format != signatureJWS {
+		return fmt.Errorf("invalid --signature format '%v' (expected '%v' or '%v')", format, signatureRSA, signatureJWS)
+	}
+
+	return nil
 }
 
-func verify(uname string, acl, signature []byte, dir string) error {
+func verify(uname string, acl, signature []byte, dir string) (*auth.Signer, error) {
 	return auth.Verify(uname, acl, signature, dir)
 }
 
-func report(diff map[uint32]acl.Diff, format string, w io.Writer) error {
+// Logs the verified signer, warning if the (unsigned) archive uname does not match the signing key, and
+// returns an error if the signing key is not one of the keys pinned for the ACL source URL.
+func checkSigner(uri string, a *archive, signer *auth.Signer, p pins) error {
+	log.Infof("Verified ACL from %v signed by %v", uri, signer)
+
+	if a.uname != signer.Name {
+		log.Warnf("SECURITY  ACL from %v is labelled as signed by '%v' but was signed by %v", uri, a.uname, signer)
+	}
+
+	if err := p.check(uri, signer); err != nil {
+		log.Warnf("SECURITY  %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func encrypt(acl []byte, recipients string) ([]byte, error) {
+	return auth.Encrypt(acl, recipients)
+}
+
+func decrypt(acl []byte, keyfile string, passphrase string) ([]byte, error) {
+	return auth.Decrypt(acl, keyfile, passphrase)
+}
+
+func report(diff map[uint32]acl.Diff, groups map[string]GroupDiff, signer *auth.Signer, format string, w io.Writer) error {
 	t, err := template.New("report").Parse(format)
 	if err != nil {
 		return err
@@ -365,7 +643,9 @@ func report(diff map[uint32]acl.Diff, format string, w io.Writer) error {
 
 	rpt := Report{
 		DateTime: &timestamp,
+		Signer:   signer,
 		Diffs:    diff,
+		Groups:   groups,
 	}
 
 	return t.Execute(w, rpt)
@@ -1,7 +1,6 @@
 package commands
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	"io"
@@ -9,30 +8,35 @@ import (
 	"net/url"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/uhppoted/uhppote-core/uhppote"
 	"github.com/uhppoted/uhppoted-lib/acl"
 	"github.com/uhppoted/uhppoted-lib/config"
 	"github.com/uhppoted/uhppoted-lib/eventlog"
 
+	"github.com/uhppoted/uhppoted-app-s3/auth"
 	"github.com/uhppoted/uhppoted-app-s3/log"
 )
 
 var CompareACLCmd = CompareACL{
 	config:      config.DefaultConfig,
 	keysdir:     DEFAULT_KEYSDIR,
+	sitekey:     DEFAULT_SITEKEY,
 	keyfile:     DEFAULT_KEYFILE,
+	signature:   signatureJWS,
 	credentials: DEFAULT_CREDENTIALS,
 	profile:     DEFAULT_PROFILE,
 	region:      DEFAULT_REGION,
+	entry:       "acl-{{.DateTime}}",
+	policy:      policyAll,
 	withPIN:     false,
 	logFile:     DEFAULT_LOGFILE,
 	logFileSize: DEFAULT_LOGFILESIZE,
 	noverify:    false,
 	nolog:       false,
 	debug:       false,
-	template: `ACL DIFF REPORT {{ .DateTime }}
+	template: `ACL DIFF REPORT {{ .DateTime }}{{if .Signer}}
+  SIGNED BY {{ .Signer }}{{end}}
 {{range $id,$value := .Diffs}}
   DEVICE {{ $id }}{{if or $value.Updated $value.Added $value.Deleted}}{{else}} OK{{end}}{{if $value.Updated}}
     Incorrect:  {{range $value.Updated}}{{.}}
@@ -40,26 +44,42 @@ var CompareACLCmd = CompareACL{
     Missing:    {{range $value.Added}}{{.}}
                 {{end}}{{end}}{{if $value.Deleted}}
     Unexpected: {{range $value.Deleted}}{{.}}
-                {{end}}{{end}}{{end}}
+                {{end}}{{end}}{{end}}{{if .Groups}}
+{{range $group,$value := .Groups}}
+  GROUP {{ $group }}{{if or $value.Updated $value.Added $value.Deleted}}{{else}} OK{{end}}{{if $value.Updated}}
+    Incorrect:  {{range $value.Updated}}{{.}}
+                {{end}}{{end}}{{if $value.Added}}
+    Missing:    {{range $value.Added}}{{.}}
+                {{end}}{{end}}{{if $value.Deleted}}
+    Unexpected: {{range $value.Deleted}}{{.}}
+                {{end}}{{end}}{{end}}{{end}}
 `,
 }
 
 type CompareACL struct {
-	acl         string
-	rpt         string
-	config      string
-	keysdir     string
-	keyfile     string
-	credentials string
-	profile     string
-	region      string
-	withPIN     bool
-	logFile     string
-	logFileSize int
-	template    string
-	noverify    bool
-	nolog       bool
-	debug       bool
+	acl            string
+	reports        destinations
+	policy         string
+	site           string
+	entry          string
+	config         string
+	keysdir        string
+	sitekey        string
+	sitePassphrase string
+	keyfile        string
+	passphrase     string
+	signature      string
+	credentials    string
+	profile        string
+	region         string
+	withPIN        bool
+	logFile        string
+	logFileSize    int
+	template       string
+	pins           pins
+	noverify       bool
+	nolog          bool
+	debug          bool
 }
 
 func (cmd *CompareACL) Name() string {
@@ -70,13 +90,20 @@ func (cmd *CompareACL) FlagSet() *flag.FlagSet {
 	flagset := flag.NewFlagSet("compare-acl", flag.ExitOnError)
 
 	flagset.StringVar(&cmd.acl, "acl", cmd.acl, "The URL for the authoritative ACL file")
-	flagset.StringVar(&cmd.rpt, "report", cmd.rpt, "The URL for the uploaded report file")
+	flagset.Var(&cmd.reports, "report", "The URL for the uploaded report file (may include {{.Date}}, {{.Time}}, {{.DateTime}}, {{.Hostname}}, {{.Site}} and {{.Controller}} placeholders). May be repeated to upload to multiple destinations")
+	flagset.StringVar(&cmd.policy, "policy", cmd.policy, "Upload policy for multiple --report destinations ('all' fails if any upload fails, 'any' fails only if every upload fails)")
+	flagset.StringVar(&cmd.site, "site", cmd.site, "Site name for the {{.Site}} placeholder")
+	flagset.StringVar(&cmd.entry, "entry", cmd.entry, "Report file name (without extension) in the uploaded archive (may include placeholders)")
 	flagset.StringVar(&cmd.credentials, "credentials", cmd.credentials, "AWS credentials file")
 	flagset.StringVar(&cmd.profile, "profile", cmd.profile, "AWS credentials file profile (defaults to 'default')")
 	flagset.StringVar(&cmd.region, "region", cmd.region, "AWS region for S3 (defaults to us-east-1)")
 	flagset.BoolVar(&cmd.withPIN, "with-pin", cmd.withPIN, "Includes the card keypad PIN codes in the ACL comparison")
 	flagset.StringVar(&cmd.keysdir, "keys", cmd.keysdir, "Sets the directory to search for RSA signing keys. Key files are expected to be named '<uname>.pub'")
-	flagset.StringVar(&cmd.keyfile, "key", cmd.keyfile, "RSA signing key")
+	flagset.StringVar(&cmd.sitekey, "site-key", cmd.sitekey, "RSA private key for decrypting encrypted ACL files")
+	flagset.StringVar(&cmd.sitePassphrase, "site-key-passphrase", cmd.sitePassphrase, "Passphrase for an encrypted site private key, specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.StringVar(&cmd.keyfile, "key", cmd.keyfile, "RSA signing key file (or PKCS#11 URI for a key held in a PKCS#11 token)")
+	flagset.StringVar(&cmd.passphrase, "passphrase", cmd.passphrase, "Passphrase for an encrypted RSA signing key (or PKCS#11 token PIN), specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.StringVar(&cmd.signature, "signature", cmd.signature, "Signature format, either 'rsa' (raw RSA signature) or 'jws' (JSON signature envelope). Defaults to 'jws'")
 	flagset.BoolVar(&cmd.noverify, "no-verify", cmd.noverify, "Disables verification of the downloaded ACL RSA signature")
 	flagset.BoolVar(&cmd.nolog, "no-log", cmd.nolog, "Writes log messages to stdout rather than a rotatable log file")
 
@@ -93,10 +120,17 @@ func (cmd *CompareACL) Usage() string {
 
 func (cmd *CompareACL) Help() {
 	fmt.Println()
-	fmt.Printf("  Usage: %s [--debug] [--config <file>] compare--acl --acl <URL> --report <URL> [--credentials <file>] [--profile <file>] [--region <region>] [--keys <dir>] [--key <file>] [--no-verify] [--no-log]\n", APP)
+	fmt.Printf("  Usage: %s [--debug] [--config <file>] compare--acl --acl <URL> --report <URL> [--report <URL>...] [--policy all|any] [--site <name>] [--entry <name>] [--credentials <file>] [--profile <file>] [--region <region>] [--keys <dir>] [--site-key <file>] [--site-key-passphrase <source>] [--key <file>] [--passphrase <source>] [--signature rsa|jws] [--no-verify] [--no-log]\n", APP)
 	fmt.Println()
 	fmt.Println("    Retrieves the ACL from the controllers configured in the configuration file, compares it to the authoritative ACL")
-	fmt.Println("    fetched from the --acl URL and uploads the comparison report to the --report URL.")
+	fmt.Println("    fetched from the --acl URL and uploads the comparison report to the --report URL. For ACL files that assign")
+	fmt.Println("    cards to groups, the report also summarises the differences for each group.")
+	fmt.Println()
+	fmt.Println("    The report URL and archive entry name may include {{.Date}}, {{.Time}}, {{.DateTime}}, {{.Hostname}}, {{.Site}}")
+	fmt.Println("    and {{.Controller}} placeholders, e.g. s3://uhppoted/{{.Site}}/reports/{{.Date}}.tar.gz.")
+	fmt.Println()
+	fmt.Println("    The --report option may be repeated to upload the signed report to multiple destinations. The --policy option")
+	fmt.Println("    determines whether compare-acl fails if any upload fails ('all', the default) or only if every upload fails ('any').")
 	fmt.Println()
 
 	helpOptions(cmd.FlagSet())
@@ -114,17 +148,25 @@ func (cmd *CompareACL) Execute(args ...interface{}) error {
 		return fmt.Errorf("compare-acl requires a URL for the authoritative ACL file")
 	}
 
-	if strings.TrimSpace(cmd.rpt) == "" {
+	if len(cmd.reports) == 0 {
 		return fmt.Errorf("compare-acl requires a URL to upload the compare report")
 	}
 
+	if err := checkPolicy(cmd.policy); err != nil {
+		return err
+	}
+
 	uri, err := url.Parse(cmd.acl)
 	if err != nil {
 		return fmt.Errorf("invalid ACL file URL '%s' (%w)", cmd.acl, err)
 	}
 
+	if err := checkSignatureFormat(cmd.signature); err != nil {
+		return err
+	}
+
 	conf := config.NewConfig()
-	if err := conf.Load(cmd.config); err != nil {
+	if cmd.pins, err = loadConfig(cmd.config, conf); err != nil {
 		return fmt.Errorf("WARN  Could not load configuration (%v)", err)
 	}
 
@@ -169,39 +211,63 @@ func (cmd *CompareACL) execute(u uhppote.IUHPPOTE, uri string, devices []uhppote
 
 	log.Infof("Fetched ACL from %v (%d bytes)", uri, len(b))
 
-	x := untar
-	if strings.HasSuffix(uri, ".zip") {
-		x = unzip
-	}
-
-	files, uname, err := x(bytes.NewReader(b))
+	a, err := unpack(uri, b)
 	if err != nil {
 		return err
 	}
 
-	tsv, ok := files["ACL"]
-	if !ok {
-		return fmt.Errorf("ACL file missing from tar.gz")
-	}
-
-	signature, ok := files["signature"]
-	if !cmd.noverify && !ok {
-		return fmt.Errorf("'signature' file missing from tar.gz")
-	}
+	tsv := a.acl
+	signature := a.signature
 
 	log.Infof("Extracted ACL from %v: %v bytes, signature: %v bytes", uri, len(tsv), len(signature))
 
+	var signer *auth.Signer
 	if !cmd.noverify {
-		if err := verify(uname, tsv, signature, cmd.keysdir); err != nil {
+		if _, signer, err = a.verify(cmd.keysdir); err != nil {
+			return err
+		}
+
+		if err := checkSigner(uri, a, signer, cmd.pins); err != nil {
+			return err
+		}
+	}
+
+	if a.encrypted {
+		if tsv, err = decrypt(a.acl, cmd.sitekey, cmd.sitePassphrase); err != nil {
 			return err
 		}
+
+		log.Infof("Decrypted ACL (%v bytes)", len(tsv))
+	}
+
+	if a.delta {
+		return fmt.Errorf("ACL from %v is a delta ACL (expected full ACL)", uri)
+	}
+
+	format, err := a.format()
+	if err != nil {
+		return err
 	}
 
-	list, warnings, err := acl.ParseTSV(bytes.NewReader(tsv), devices, false)
+	g, err := a.groupDefinitions()
 	if err != nil {
 		return err
 	}
 
+	list, warnings, err := parseACL(tsv, format, g, devices, false)
+	if err != nil {
+		return err
+	}
+
+	var m members
+	if g != nil {
+		if table, err := parseTable(tsv, format); err != nil {
+			return err
+		} else if m, err = membership(table); err != nil {
+			return err
+		}
+	}
+
 	for _, w := range warnings {
 		log.Warnf("%v", w)
 	}
@@ -230,7 +296,15 @@ func (cmd *CompareACL) execute(u uhppote.IUHPPOTE, uri string, devices []uhppote
 			log.Infof("%v  SUMMARY  same:%v  different:%v  missing:%v  extraneous:%v", k, len(v.Unchanged), len(v.Updated), len(v.Added), len(v.Deleted))
 		}
 
-		return cmd.upload(diff)
+		var groups map[string]GroupDiff
+		if g != nil {
+			groups = compareGroups(diff, m)
+			for k, v := range groups {
+				log.Infof("GROUP %v  SUMMARY  same:%v  different:%v  missing:%v  extraneous:%v", k, len(v.Unchanged), len(v.Updated), len(v.Added), len(v.Deleted))
+			}
+		}
+
+		return cmd.upload(newPlaceholders(cmd.site, devices), diff, groups, signer)
 	}
 }
 
@@ -258,51 +332,46 @@ func (cmd *CompareACL) storeFile(url string, r io.Reader) error {
 	return storeFile(url, r)
 }
 
-func (cmd *CompareACL) upload(diff map[uint32]acl.Diff) error {
+func (cmd *CompareACL) upload(values placeholders, diff map[uint32]acl.Diff, groups map[string]GroupDiff, signer *auth.Signer) error {
 	log.Infof("Uploading ACL 'diff' report")
 
+	uris, err := cmd.reports.expand(values)
+	if err != nil {
+		return err
+	}
+
+	filename, err := values.entry(cmd.entry, ".rpt")
+	if err != nil {
+		return err
+	}
+
 	var w strings.Builder
 
-	if err := report(diff, cmd.template, &w); err != nil {
+	if err := report(diff, groups, signer, cmd.template, &w); err != nil {
 		return err
 	}
 
-	filename := time.Now().Format("acl-2006-01-02T150405.rpt")
 	rpt := []byte(w.String())
-	signature, err := sign(rpt, cmd.keyfile)
+	signature, err := sign(rpt, cmd.keyfile, cmd.passphrase, cmd.signature)
 	if err != nil {
 		return err
 	}
 
-	var b bytes.Buffer
 	var files = map[string][]byte{
 		filename:    rpt,
 		"signature": signature,
 	}
 
-	x := targz
-	if strings.HasSuffix(cmd.rpt, ".zip") {
-		x = zipf
-	}
-
-	if err := x(files, &b); err != nil {
-		return err
-	}
-
-	log.Infof("tar'd report (%v bytes) and signature (%v bytes): %v bytes", len(rpt), len(signature), b.Len())
+	return uploadAll(uris, files, cmd.policy, cmd.put)
+}
 
+func (cmd *CompareACL) put(uri string, r io.Reader) error {
 	f := cmd.storeHTTP
-	if strings.HasPrefix(cmd.rpt, "s3://") {
+	if strings.HasPrefix(uri, "s3://") {
 		f = cmd.storeS3
-	} else if strings.HasPrefix(cmd.rpt, "file://") {
+	} else if strings.HasPrefix(uri, "file://") {
 		f = cmd.storeFile
 	}
 
-	if err := f(cmd.rpt, bytes.NewReader(b.Bytes())); err != nil {
-		return err
-	}
-
-	log.Infof("Uploaded to %v", cmd.rpt)
-
-	return nil
+	return f(uri, r)
 }
@@ -4,6 +4,8 @@ const (
 	DEFAULT_WORKDIR     = "/usr/local/var/com.github.uhppoted"
 	DEFAULT_KEYSDIR     = "/usr/local/etc/com.github.uhppoted/acl/keys"
 	DEFAULT_KEYFILE     = "/usr/local/etc/com.github.uhppoted/acl/keys/uhppoted"
+	DEFAULT_SITEKEY     = "/usr/local/etc/com.github.uhppoted/acl/keys/site"
+	DEFAULT_RECIPIENTS  = "/usr/local/etc/com.github.uhppoted/acl/recipients"
 	DEFAULT_CREDENTIALS = ""
 	DEFAULT_PROFILE     = ""
 	DEFAULT_REGION      = ""
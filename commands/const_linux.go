@@ -4,6 +4,8 @@ const (
 	DEFAULT_WORKDIR     = "/var/uhppoted"
 	DEFAULT_KEYSDIR     = "/etc/uhppoted/acl/keys"
 	DEFAULT_KEYFILE     = "/etc/uhppoted/acl/keys/uhppoted"
+	DEFAULT_SITEKEY     = "/etc/uhppoted/acl/keys/site"
+	DEFAULT_RECIPIENTS  = "/etc/uhppoted/acl/recipients"
 	DEFAULT_CREDENTIALS = ""
 	DEFAULT_PROFILE     = ""
 	DEFAULT_REGION      = ""
@@ -7,6 +7,8 @@ import (
 var DEFAULT_WORKDIR = workdir()
 var DEFAULT_KEYSDIR = filepath.Join(workdir(), "acl", "keys")
 var DEFAULT_KEYFILE = filepath.Join(workdir(), "acl", "keys", "uhppoted")
+var DEFAULT_SITEKEY = filepath.Join(workdir(), "acl", "keys", "site")
+var DEFAULT_RECIPIENTS = filepath.Join(workdir(), "acl", "recipients")
 var DEFAULT_CREDENTIALS = ""
 var DEFAULT_PROFILE = ""
 var DEFAULT_REGION = ""
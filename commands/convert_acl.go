@@ -0,0 +1,367 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/uhppoted/uhppoted-lib/acl"
+	"github.com/uhppoted/uhppoted-lib/config"
+)
+
+var ConvertACLCmd = ConvertACL{
+	config:      config.DefaultConfig,
+	keysdir:     DEFAULT_KEYSDIR,
+	sitekey:     DEFAULT_SITEKEY,
+	credentials: DEFAULT_CREDENTIALS,
+	profile:     DEFAULT_PROFILE,
+	region:      DEFAULT_REGION,
+	signature:   signatureJWS,
+	noverify:    false,
+}
+
+type ConvertACL struct {
+	acl            string
+	aclDelim       string
+	aclSheet       string
+	out            string
+	format         string
+	delimiter      string
+	sheet          string
+	groups         string
+	uname          string
+	keyfile        string
+	passphrase     string
+	signature      string
+	sequence       uint64
+	config         string
+	keysdir        string
+	sitekey        string
+	sitePassphrase string
+	credentials    string
+	profile        string
+	region         string
+	noverify       bool
+	pins           pins
+}
+
+func (cmd *ConvertACL) Name() string {
+	return "convert-acl"
+}
+
+func (cmd *ConvertACL) FlagSet() *flag.FlagSet {
+	flagset := flag.NewFlagSet("convert-acl", flag.ExitOnError)
+
+	flagset.StringVar(&cmd.acl, "acl", cmd.acl, "ACL file (or https://, s3:// or file:// URL of an ACL file or signed ACL archive) to convert")
+	flagset.StringVar(&cmd.aclDelim, "acl-delimiter", cmd.aclDelim, "CSV ACL file delimiter for an ACL file that is not in a signed archive (defaults to ',')")
+	flagset.StringVar(&cmd.aclSheet, "acl-sheet", cmd.aclSheet, "XLSX ACL file worksheet for an ACL file that is not in a signed archive (defaults to the first worksheet)")
+	flagset.StringVar(&cmd.out, "out", cmd.out, "Converted ACL file (or signed .tar.gz or .zip ACL archive if --key is specified)")
+	flagset.StringVar(&cmd.format, "format", cmd.format, "Converted ACL file format (tsv, csv, json or xlsx). Defaults to the format for the --out file extension")
+	flagset.StringVar(&cmd.delimiter, "delimiter", cmd.delimiter, "Converted CSV ACL file delimiter (defaults to ',')")
+	flagset.StringVar(&cmd.sheet, "sheet", cmd.sheet, "Converted XLSX ACL file worksheet (defaults to 'ACL')")
+	flagset.StringVar(&cmd.groups, "groups", cmd.groups, "Group definitions file to include in the signed ACL archive (defaults to the group definitions in the source archive)")
+	flagset.StringVar(&cmd.uname, "uname", cmd.uname, "User ID of the signing key")
+	flagset.StringVar(&cmd.keyfile, "key", cmd.keyfile, "RSA signing key file (or PKCS#11 URI) for re-signing the converted ACL")
+	flagset.StringVar(&cmd.passphrase, "passphrase", cmd.passphrase, "Passphrase for an encrypted RSA signing key (or PKCS#11 token PIN), specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.StringVar(&cmd.signature, "signature", cmd.signature, "Signature format, either 'rsa' (raw RSA signature) or 'jws' (JSON signature envelope). Defaults to 'jws'")
+	flagset.Uint64Var(&cmd.sequence, "sequence", cmd.sequence, "ACL sequence number for the manifest (defaults to the current UNIX time)")
+	flagset.StringVar(&cmd.credentials, "credentials", cmd.credentials, "AWS credentials file")
+	flagset.StringVar(&cmd.profile, "profile", cmd.profile, "AWS credentials file profile (defaults to 'default')")
+	flagset.StringVar(&cmd.region, "region", cmd.region, "AWS region for S3 (defaults to us-east-1)")
+	flagset.StringVar(&cmd.keysdir, "keys", cmd.keysdir, "Sets the directory to search for RSA signing keys. Key files are expected to be named '<uname>.pub'")
+	flagset.StringVar(&cmd.sitekey, "site-key", cmd.sitekey, "RSA private key for decrypting encrypted ACL files")
+	flagset.StringVar(&cmd.sitePassphrase, "site-key-passphrase", cmd.sitePassphrase, "Passphrase for an encrypted site private key, specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.BoolVar(&cmd.noverify, "no-verify", cmd.noverify, "Disables verification of the source ACL archive signature")
+
+	return flagset
+}
+
+func (cmd *ConvertACL) Description() string {
+	return "Converts an ACL file between the TSV, CSV, JSON and XLSX formats"
+}
+
+func (cmd *ConvertACL) Usage() string {
+	return "convert-acl --acl <file|URL> --out <file>"
+}
+
+func (cmd *ConvertACL) Help() {
+	fmt.Println()
+	fmt.Printf("  Usage: %s [--config <file>] convert-acl --acl <file|URL> [--acl-delimiter <char>] [--acl-sheet <name>] [--format <format>] [--delimiter <char>] [--sheet <name>] [--groups <file>] [--uname <user ID> --key <file> [--passphrase <source>] [--sequence <number>] [--signature rsa|jws]] [--keys <dir>] [--site-key <file>] [--site-key-passphrase <source>] [--credentials <file>] [--profile <file>] [--region <region>] [--no-verify] --out <file>\n", APP)
+	fmt.Println()
+	fmt.Println("    Reads an ACL file (or the ACL file in a signed ACL archive) in any of the supported formats and writes it in")
+	fmt.Println("    another format, retaining the column order, PINs and card groups. The converted ACL is optionally re-signed")
+	fmt.Println("    and packaged as a .tar.gz (or .zip) archive for load-acl and compare-acl.")
+	fmt.Println()
+
+	helpOptions(cmd.FlagSet())
+	fmt.Println()
+}
+
+func (cmd *ConvertACL) Execute(args ...interface{}) error {
+	options := args[0].(*Options)
+
+	cmd.config = options.Config
+
+	if strings.TrimSpace(cmd.acl) == "" {
+		return fmt.Errorf("convert-acl requires an ACL file or URL")
+	}
+
+	if strings.TrimSpace(cmd.out) == "" {
+		return fmt.Errorf("convert-acl requires an output file")
+	}
+
+	signed := strings.HasSuffix(cmd.out, ".tar.gz") || strings.HasSuffix(cmd.out, ".zip")
+	switch {
+	case signed && strings.TrimSpace(cmd.keyfile) == "":
+		return fmt.Errorf("convert-acl requires an RSA signing key for a signed ACL archive")
+
+	case signed && strings.TrimSpace(cmd.uname) == "":
+		return fmt.Errorf("convert-acl requires the user ID of the signing key for a signed ACL archive")
+
+	case !signed && cmd.keyfile != "":
+		return fmt.Errorf("invalid output file '%v' for a signed ACL (expected .tar.gz or .zip archive)", cmd.out)
+
+	case !signed && cmd.groups != "":
+		return fmt.Errorf("ACL group definitions require a signed ACL archive")
+	}
+
+	if cmd.format == "" && !signed {
+		cmd.format = aclFormat(cmd.out)
+	}
+
+	if _, ok := extensions[cmd.format]; !ok && cmd.format == "" {
+		return fmt.Errorf("convert-acl requires an ACL file format for '%v'", cmd.out)
+	} else if !ok {
+		return fmt.Errorf("invalid ACL file format '%v'", cmd.format)
+	} else if !signed && cmd.format != aclFormat(cmd.out) {
+		return fmt.Errorf("invalid output file '%v' for %v ACL file (expected %v file)", cmd.out, strings.ToUpper(cmd.format), extensions[cmd.format])
+	}
+
+	if cmd.format == formatCSV {
+		if _, err := parseDelimiter(cmd.delimiter); err != nil {
+			return err
+		}
+	}
+
+	if err := checkSignatureFormat(cmd.signature); err != nil {
+		return err
+	}
+
+	var err error
+
+	conf := config.NewConfig()
+	if cmd.pins, err = loadConfig(cmd.config, conf); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("WARN  Could not load configuration (%v)", err)
+	}
+
+	if cmd.credentials == "" {
+		cmd.credentials = conf.AWS.Credentials
+	}
+
+	if cmd.profile == "" {
+		cmd.profile = conf.AWS.Profile
+	}
+
+	if cmd.region == "" {
+		cmd.region = conf.AWS.Region
+	}
+
+	return cmd.execute(signed)
+}
+
+func (cmd *ConvertACL) execute(signed bool) error {
+	name, table, groups, err := cmd.read(cmd.acl)
+	if err != nil {
+		return err
+	}
+
+	if cmd.groups != "" {
+		if groups, err = os.ReadFile(cmd.groups); err != nil {
+			return err
+		} else if _, err := parseGroups(groups); err != nil {
+			return err
+		}
+	}
+
+	if groupsColumn(table.Header) >= 0 && groups == nil && signed {
+		return fmt.Errorf("ACL file has a 'Groups' column but no group definitions")
+	}
+
+	format := fileFormat{
+		name:      cmd.format,
+		delimiter: cmd.delimiter,
+		sheet:     cmd.sheet,
+	}
+
+	b, err := makeFile(table, format)
+	if err != nil {
+		return err
+	}
+
+	filename := strings.TrimSuffix(name, filepath.Ext(name)) + extensions[cmd.format]
+
+	fmt.Println()
+	fmt.Printf("  Converted %v (%v records) to %v\n", name, len(table.Records), strings.ToUpper(cmd.format))
+
+	if !signed {
+		if err := os.WriteFile(cmd.out, b, 0644); err != nil {
+			return err
+		}
+	} else {
+		signer := SignACL{
+			uname:      cmd.uname,
+			keyfile:    cmd.keyfile,
+			passphrase: cmd.passphrase,
+			signature:  cmd.signature,
+			sequence:   cmd.sequence,
+			delimiter:  cmd.delimiter,
+			sheet:      cmd.sheet,
+		}
+
+		files, err := signer.sign(filename, b, groups)
+		if err != nil {
+			return err
+		}
+
+		var archive bytes.Buffer
+		x := targz
+		if strings.HasSuffix(cmd.out, ".zip") {
+			x = zipf
+		}
+
+		if err := x(files, cmd.uname, &archive); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(cmd.out, archive.Bytes(), 0644); err != nil {
+			return err
+		}
+
+		fmt.Printf("  Signed %v (%v bytes) as %v: %v bytes\n", filename, len(b), cmd.uname, archive.Len())
+		if m, err := parseManifest(files["manifest"]); err == nil {
+			fmt.Printf("  Manifest %v\n", m)
+		}
+	}
+
+	if hasPINs(table) {
+		fmt.Printf("  WARNING: %v includes card PINs and is not encrypted\n", cmd.out)
+	}
+
+	fmt.Printf("  Created %v\n", cmd.out)
+	fmt.Println()
+
+	return nil
+}
+
+// Reads the ACL file (or signed ACL archive) from a local file or URL, returning the ACL file name,
+// the unvalidated ACL table and the archive group definitions (if any).
+func (cmd *ConvertACL) read(source string) (string, *acl.Table, []byte, error) {
+	var b []byte
+	var name string
+	var err error
+
+	// ... single letter schemes are Windows drive letters
+	if uri, err := url.Parse(source); err == nil && len(uri.Scheme) > 1 {
+		f := cmd.fetchHTTP
+		if uri.Scheme == "s3" {
+			f = cmd.fetchS3
+		} else if uri.Scheme == "file" {
+			f = cmd.fetchFile
+		}
+
+		if b, err = f(uri.String()); err != nil {
+			return "", nil, nil, err
+		}
+
+		name = path.Base(uri.Path)
+	} else if b, err = os.ReadFile(source); err != nil {
+		return "", nil, nil, err
+	} else {
+		name = filepath.Base(source)
+	}
+
+	if !strings.HasSuffix(name, ".tar.gz") && !strings.HasSuffix(name, ".zip") {
+		if !isACL(name) {
+			return "", nil, nil, fmt.Errorf("invalid ACL file name '%v' (expected .acl, .csv, .json or .xlsx file)", name)
+		}
+
+		if strings.HasSuffix(name, ".enc") {
+			if b, err = decrypt(b, cmd.sitekey, cmd.sitePassphrase); err != nil {
+				return "", nil, nil, err
+			}
+		}
+
+		format := fileFormat{
+			name:      aclFormat(name),
+			delimiter: cmd.aclDelim,
+			sheet:     cmd.aclSheet,
+		}
+
+		table, err := parseTable(b, format)
+
+		return strings.TrimSuffix(name, ".enc"), table, nil, err
+	}
+
+	a, err := unpack(name, b)
+	if err != nil {
+		return "", nil, nil, err
+	} else if a.delta {
+		return "", nil, nil, fmt.Errorf("delta ACL files cannot be converted")
+	}
+
+	if !cmd.noverify {
+		if _, signer, err := a.verify(cmd.keysdir); err != nil {
+			return "", nil, nil, err
+		} else if err := cmd.pins.check(source, signer); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	body := a.acl
+	if a.encrypted {
+		if body, err = decrypt(a.acl, cmd.sitekey, cmd.sitePassphrase); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	format, err := a.format()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	table, err := parseTable(body, format)
+
+	return strings.TrimSuffix(a.name, ".enc"), table, a.groups, err
+}
+
+func (cmd *ConvertACL) fetchHTTP(url string) ([]byte, error) {
+	return fetchHTTP(url)
+}
+
+func (cmd *ConvertACL) fetchS3(url string) ([]byte, error) {
+	return fetchS3(url, cmd.credentials, cmd.profile, cmd.region)
+}
+
+func (cmd *ConvertACL) fetchFile(url string) ([]byte, error) {
+	return fetchFile(url)
+}
+
+func hasPINs(table *acl.Table) bool {
+	for i, h := range table.Header {
+		if normalise(h) == "pin" {
+			for _, record := range table.Records {
+				if strings.TrimSpace(field(record, i)) != "" {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,286 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/uhppoted/uhppote-core/types"
+	"github.com/uhppoted/uhppote-core/uhppote"
+	"github.com/uhppoted/uhppoted-lib/acl"
+)
+
+// Delta ACL file, listing the cards to add, update or revoke relative to a base ACL identified by
+// the SHA-256 digest of the canonical TSV ACL (i.e. the uhppoted.acl file created by store-acl
+// without PINs), e.g.
+//
+//	{
+//	  "base": "9f2c4e...07",
+//	  "full": "s3://uhppoted/hogwarts/acl.tar.gz",
+//	  "add": [
+//	    { "card": 10058402, "from": "2026-01-01", "to": "2026-12-31", "doors": { "Front Door": true } }
+//	  ],
+//	  "update": [
+//	    { "card": 10058400, "from": "2026-01-01", "to": "2026-12-31", "doors": { "Garage": 29 } }
+//	  ],
+//	  "revoke": [ 10058401 ]
+//	}
+//
+// The added and updated cards have the same structure as the JSON ACL file format. The (optional)
+// 'full' URL is the full ACL to load if the controllers do not match the base ACL.
+type delta struct {
+	Base   string     `json:"base"`
+	Full   string     `json:"full,omitempty"`
+	Add    []jsonCard `json:"add,omitempty"`
+	Update []jsonCard `json:"update,omitempty"`
+	Revoke []uint32   `json:"revoke,omitempty"`
+}
+
+// Delta ACL files are identified by the .delta extension, ignoring the .enc extension of encrypted
+// delta ACL files.
+func isDelta(filename string) bool {
+	return filepath.Ext(strings.TrimSuffix(filename, ".enc")) == ".delta"
+}
+
+func parseDelta(b []byte) (*delta, error) {
+	var d delta
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&d); err != nil {
+		return nil, fmt.Errorf("invalid delta ACL file (%w)", err)
+	}
+
+	if _, err := hex.DecodeString(d.Base); err != nil || len(d.Base) != 2*sha256.Size {
+		return nil, fmt.Errorf("invalid delta ACL file (invalid base ACL SHA-256 digest '%v')", d.Base)
+	}
+
+	return &d, nil
+}
+
+// Returns the SHA-256 digest of the canonical TSV representation of an ACL.
+func aclDigest(list acl.ACL, devices []uhppote.Device) (string, error) {
+	var b bytes.Buffer
+	if err := acl.MakeTSV(list, devices, &b); err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(b.Bytes())
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Returns the ACL that results from applying the delta to the base ACL, along with the added,
+// updated and revoked cards for each controller. Adding a card that is already in the base ACL or
+// updating or revoking a card that is not in the base ACL is an error.
+func (d delta) apply(base acl.ACL, g groups, devices []uhppote.Device) (acl.ACL, map[uint32]acl.Diff, error) {
+	cards := acl.ACL{}
+
+	if len(d.Add) > 0 || len(d.Update) > 0 {
+		table := withoutHolder(cardsTable(slices.Concat(d.Add, d.Update)))
+		if g != nil {
+			var err error
+			if table, err = g.expand(table); err != nil {
+				return nil, nil, err
+			}
+		} else if groupsColumn(table.Header) >= 0 {
+			return nil, nil, fmt.Errorf("delta ACL assigns cards to groups but has no group definitions")
+		}
+
+		list, _, err := acl.ParseTable(table, devices, true)
+		if err != nil {
+			return nil, nil, err
+		} else if list == nil {
+			return nil, nil, fmt.Errorf("invalid delta ACL file")
+		}
+
+		cards = *list
+	}
+
+	exists := func(card uint32) bool {
+		for _, v := range base {
+			if _, ok := v[card]; ok {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, c := range d.Add {
+		if exists(c.Card) {
+			return nil, nil, fmt.Errorf("delta ACL adds card %v which is already in the base ACL", c.Card)
+		}
+	}
+
+	for _, c := range d.Update {
+		if !exists(c.Card) {
+			return nil, nil, fmt.Errorf("delta ACL updates card %v which is not in the base ACL", c.Card)
+		}
+	}
+
+	for _, card := range d.Revoke {
+		if !exists(card) {
+			return nil, nil, fmt.Errorf("delta ACL revokes card %v which is not in the base ACL", card)
+		}
+
+		if slices.ContainsFunc(slices.Concat(d.Add, d.Update), func(c jsonCard) bool { return c.Card == card }) {
+			return nil, nil, fmt.Errorf("delta ACL revokes card %v which is also added or updated", card)
+		}
+	}
+
+	list := acl.ACL{}
+	changes := map[uint32]acl.Diff{}
+
+	for id, v := range base {
+		list[id] = maps.Clone(v)
+		diff := acl.Diff{
+			Unchanged: []types.Card{},
+			Updated:   []types.Card{},
+			Added:     []types.Card{},
+			Deleted:   []types.Card{},
+		}
+
+		for _, c := range d.Add {
+			if card, ok := cards[id][c.Card]; ok {
+				list[id][c.Card] = card
+				diff.Added = append(diff.Added, card)
+			}
+		}
+
+		for _, c := range d.Update {
+			if card, ok := cards[id][c.Card]; ok {
+				if _, ok := v[c.Card]; ok {
+					diff.Updated = append(diff.Updated, card)
+				} else {
+					diff.Added = append(diff.Added, card)
+				}
+
+				list[id][c.Card] = card
+			}
+		}
+
+		for _, card := range d.Revoke {
+			if c, ok := v[card]; ok {
+				delete(list[id], card)
+				diff.Deleted = append(diff.Deleted, c)
+			}
+		}
+
+		changes[id] = diff
+	}
+
+	return list, changes, nil
+}
+
+// Writes the delta changes to the controllers, retaining the existing card PINs unless withPIN is set.
+func putDelta(u uhppote.IUHPPOTE, base acl.ACL, changes map[uint32]acl.Diff, withPIN bool, dryrun bool) map[uint32]acl.Report {
+	reports := map[uint32]acl.Report{}
+
+	for id, diff := range changes {
+		report := acl.Report{
+			Unchanged: []uint32{},
+			Updated:   []uint32{},
+			Added:     []uint32{},
+			Deleted:   []uint32{},
+			Failed:    []uint32{},
+			Errored:   []uint32{},
+			Errors:    []error{},
+		}
+
+		put := func(card types.Card) (bool, error) {
+			if current, ok := base[id][card.CardNumber]; ok && !withPIN {
+				card.PIN = current.PIN
+			} else if !withPIN {
+				card.PIN = 0
+			}
+
+			if err := validateProfiles(u, id, card); err != nil {
+				return false, err
+			} else if dryrun {
+				return true, nil
+			}
+
+			return u.PutCard(id, card)
+		}
+
+		revoke := func(card types.Card) (bool, error) {
+			if dryrun {
+				return true, nil
+			}
+
+			return u.DeleteCard(id, card.CardNumber)
+		}
+
+		for _, card := range diff.Updated {
+			if ok, err := put(card); err != nil {
+				report.Errored = append(report.Errored, card.CardNumber)
+				report.Errors = append(report.Errors, err)
+			} else if !ok {
+				report.Failed = append(report.Failed, card.CardNumber)
+			} else {
+				report.Updated = append(report.Updated, card.CardNumber)
+			}
+		}
+
+		for _, card := range diff.Added {
+			if ok, err := put(card); err != nil {
+				report.Errored = append(report.Errored, card.CardNumber)
+				report.Errors = append(report.Errors, err)
+			} else if !ok {
+				report.Failed = append(report.Failed, card.CardNumber)
+			} else {
+				report.Added = append(report.Added, card.CardNumber)
+			}
+		}
+
+		for _, card := range diff.Deleted {
+			if ok, err := revoke(card); err != nil {
+				report.Errored = append(report.Errored, card.CardNumber)
+				report.Errors = append(report.Errors, err)
+			} else if !ok {
+				report.Failed = append(report.Failed, card.CardNumber)
+			} else {
+				report.Deleted = append(report.Deleted, card.CardNumber)
+			}
+		}
+
+		changed := map[uint32]bool{}
+		for _, card := range slices.Concat(diff.Updated, diff.Deleted) {
+			changed[card.CardNumber] = true
+		}
+
+		for card := range base[id] {
+			if !changed[card] {
+				report.Unchanged = append(report.Unchanged, card)
+			}
+		}
+
+		reports[id] = report
+	}
+
+	return reports
+}
+
+// Returns an error if the card references a time profile that is not defined on the controller
+// (matches the uhppoted-lib PutACL validation).
+func validateProfiles(u uhppote.IUHPPOTE, deviceID uint32, card types.Card) error {
+	for _, door := range []uint8{1, 2, 3, 4} {
+		if v, ok := card.Doors[door]; ok && v >= 2 && v <= 254 {
+			if profile, err := u.GetTimeProfile(deviceID, uint8(v)); err != nil {
+				return err
+			} else if profile == nil {
+				return fmt.Errorf("time profile %v is not defined for %v", v, deviceID)
+			}
+		}
+	}
+
+	return nil
+}
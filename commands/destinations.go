@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/uhppoted/uhppoted-app-s3/log"
+)
+
+// Upload policies for multiple upload destinations: 'all' fails if any upload fails and 'any' fails
+// only if every upload fails.
+const (
+	policyAll = "all"
+	policyAny = "any"
+)
+
+// Upload destinations for the repeatable --url (store-acl) and --report (compare-acl) options.
+type destinations []string
+
+func (d *destinations) String() string {
+	return strings.Join(*d, ",")
+}
+
+func (d *destinations) Set(v string) error {
+	if strings.TrimSpace(v) == "" {
+		return fmt.Errorf("invalid upload URL '%v'", v)
+	}
+
+	*d = append(*d, v)
+
+	return nil
+}
+
+// Returns the destination URLs with the placeholders replaced by the placeholder values.
+func (d destinations) expand(values placeholders) ([]string, error) {
+	uris := []string{}
+	for _, s := range d {
+		expanded, err := values.expand(s)
+		if err != nil {
+			return nil, err
+		}
+
+		uri, err := url.Parse(expanded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload URL '%s' (%w)", expanded, err)
+		}
+
+		uris = append(uris, uri.String())
+	}
+
+	return uris, nil
+}
+
+func checkPolicy(policy string) error {
+	if policy != policyAll && policy != policyAny {
+		return fmt.Errorf("invalid upload --policy '%v' (expected '%v' or '%v')", policy, policyAll, policyAny)
+	}
+
+	return nil
+}
+
+// Archives the files and uploads the archive to each URL (as a .zip archive for URLs ending in .zip and as
+// a .tar.gz archive otherwise), logging the result for each destination. Returns an error if any upload
+// failed for the 'all' policy or if every upload failed for the 'any' policy.
+func uploadAll(uris []string, files map[string][]byte, policy string, store func(string, io.Reader) error) error {
+	archives := map[bool][]byte{}
+	uploaded := 0
+	errors := []error{}
+
+	for _, uri := range uris {
+		zipped := strings.HasSuffix(uri, ".zip")
+		b, ok := archives[zipped]
+		if !ok {
+			var buffer bytes.Buffer
+			x := targz
+			if zipped {
+				x = zipf
+			}
+
+			if err := x(files, "uhppoted", &buffer); err != nil {
+				return err
+			}
+
+			b = buffer.Bytes()
+			archives[zipped] = b
+
+			log.Infof("Archived %v files (signature %v bytes): %v bytes", len(files), len(files["signature"]), len(b))
+		}
+
+		if err := store(uri, bytes.NewReader(b)); err != nil {
+			log.Warnf("Error uploading to %v (%v)", uri, err)
+			errors = append(errors, fmt.Errorf("%v: %w", uri, err))
+		} else {
+			log.Infof("Uploaded to %v", uri)
+			uploaded++
+		}
+	}
+
+	if len(uris) > 1 {
+		log.Infof("Uploaded to %v of %v destinations (policy:%v)", uploaded, len(uris), policy)
+	}
+
+	if len(errors) > 0 && (policy != policyAny || uploaded == 0) {
+		return fmt.Errorf("%v", errors)
+	}
+
+	return nil
+}
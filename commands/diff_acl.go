@@ -0,0 +1,409 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/uhppoted/uhppote-core/types"
+	"github.com/uhppoted/uhppote-core/uhppote"
+	"github.com/uhppoted/uhppoted-lib/acl"
+	"github.com/uhppoted/uhppoted-lib/config"
+)
+
+var DiffACLCmd = DiffACL{
+	config:      config.DefaultConfig,
+	keysdir:     DEFAULT_KEYSDIR,
+	sitekey:     DEFAULT_SITEKEY,
+	credentials: DEFAULT_CREDENTIALS,
+	profile:     DEFAULT_PROFILE,
+	region:      DEFAULT_REGION,
+	format:      "text",
+	withPIN:     false,
+	noverify:    false,
+	template: `ACL DIFF
+  FROM {{ .From }}
+  TO   {{ .To }}
+{{range $id,$value := .Diffs}}
+  DEVICE {{ $id }}{{if or $value.Updated $value.Added $value.Deleted}}{{else}} NO CHANGES{{end}}{{if $value.Updated}}
+    Updated:    {{range $value.Updated}}{{.}}
+                {{end}}{{end}}{{if $value.Added}}
+    Added:      {{range $value.Added}}{{.}}
+                {{end}}{{end}}{{if $value.Deleted}}
+    Deleted:    {{range $value.Deleted}}{{.}}
+                {{end}}{{end}}{{end}}{{if .Groups}}
+{{range $group,$value := .Groups}}
+  GROUP {{ $group }}{{if or $value.Updated $value.Added $value.Deleted}}{{else}} NO CHANGES{{end}}{{if $value.Updated}}
+    Updated:    {{range $value.Updated}}{{.}}
+                {{end}}{{end}}{{if $value.Added}}
+    Added:      {{range $value.Added}}{{.}}
+                {{end}}{{end}}{{if $value.Deleted}}
+    Deleted:    {{range $value.Deleted}}{{.}}
+                {{end}}{{end}}{{end}}{{end}}
+`,
+}
+
+type DiffACL struct {
+	from           string
+	to             string
+	config         string
+	keysdir        string
+	sitekey        string
+	sitePassphrase string
+	credentials    string
+	profile        string
+	region         string
+	format         string
+	withPIN        bool
+	noverify       bool
+	template       string
+	pins           pins
+}
+
+// Published ACL archive, with the verified signer and manifest sequence number (if any).
+type aclSource struct {
+	URL      string `json:"url"`
+	ACL      string `json:"acl"`
+	Signer   string `json:"signed-by,omitempty"`
+	KeyID    string `json:"key-id,omitempty"`
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+type diffReport struct {
+	From   aclSource             `json:"from"`
+	To     aclSource             `json:"to"`
+	Diffs  map[uint32]deviceDiff `json:"controllers"`
+	Groups map[string]GroupDiff  `json:"groups,omitempty"`
+}
+
+type deviceDiff struct {
+	Unchanged []uint32     `json:"unchanged"`
+	Updated   []types.Card `json:"updated"`
+	Added     []types.Card `json:"added"`
+	Deleted   []types.Card `json:"deleted"`
+}
+
+func (s aclSource) String() string {
+	switch {
+	case s.Signer != "" && s.Sequence != 0:
+		return fmt.Sprintf("%v  %v  signed by %v (%v)  sequence:%v", s.URL, s.ACL, s.Signer, s.KeyID, s.Sequence)
+
+	case s.Signer != "":
+		return fmt.Sprintf("%v  %v  signed by %v (%v)", s.URL, s.ACL, s.Signer, s.KeyID)
+
+	default:
+		return fmt.Sprintf("%v  %v  NOT VERIFIED", s.URL, s.ACL)
+	}
+}
+
+func (cmd *DiffACL) Name() string {
+	return "diff-acl"
+}
+
+func (cmd *DiffACL) FlagSet() *flag.FlagSet {
+	flagset := flag.NewFlagSet("diff-acl", flag.ExitOnError)
+
+	flagset.StringVar(&cmd.from, "from", cmd.from, "The URL of the current (or base) signed ACL archive")
+	flagset.StringVar(&cmd.to, "to", cmd.to, "The URL of the proposed signed ACL (or delta ACL) archive")
+	flagset.StringVar(&cmd.format, "format", cmd.format, "Report format (text or json)")
+	flagset.StringVar(&cmd.credentials, "credentials", cmd.credentials, "AWS credentials file")
+	flagset.StringVar(&cmd.profile, "profile", cmd.profile, "AWS credentials file profile (defaults to 'default')")
+	flagset.StringVar(&cmd.region, "region", cmd.region, "AWS region for S3 (defaults to us-east-1)")
+	flagset.BoolVar(&cmd.withPIN, "with-pin", cmd.withPIN, "Includes the card keypad PIN codes in the ACL comparison")
+	flagset.StringVar(&cmd.keysdir, "keys", cmd.keysdir, "Sets the directory to search for RSA signing keys. Key files are expected to be named '<uname>.pub'")
+	flagset.StringVar(&cmd.sitekey, "site-key", cmd.sitekey, "RSA private key for decrypting encrypted ACL files")
+	flagset.StringVar(&cmd.sitePassphrase, "site-key-passphrase", cmd.sitePassphrase, "Passphrase for an encrypted site private key, specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.BoolVar(&cmd.noverify, "no-verify", cmd.noverify, "Disables verification of the ACL archive signatures")
+
+	return flagset
+}
+
+func (cmd *DiffACL) Description() string {
+	return "Compares two signed ACL archives without contacting the controllers"
+}
+
+func (cmd *DiffACL) Usage() string {
+	return "diff-acl --from <URL> --to <URL>"
+}
+
+func (cmd *DiffACL) Help() {
+	fmt.Println()
+	fmt.Printf("  Usage: %s [--config <file>] diff-acl --from <URL> --to <URL> [--format <text|json>] [--with-pin] [--keys <dir>] [--site-key <file>] [--site-key-passphrase <source>] [--credentials <file>] [--profile <file>] [--region <region>] [--no-verify]\n", APP)
+	fmt.Println()
+	fmt.Println("    Fetches and verifies the ACL archives at the --from and --to URLs and reports the cards that would be updated,")
+	fmt.Println("    added and deleted on each of the configured controllers if the --to ACL replaced the --from ACL. A --to delta")
+	fmt.Println("    ACL is applied to the --from ACL, which must match the delta base ACL.")
+	fmt.Println()
+
+	helpOptions(cmd.FlagSet())
+	fmt.Println()
+}
+
+func (cmd *DiffACL) Execute(args ...interface{}) error {
+	options := args[0].(*Options)
+
+	cmd.config = options.Config
+
+	if strings.TrimSpace(cmd.from) == "" {
+		return fmt.Errorf("diff-acl requires a --from URL for the current ACL archive")
+	}
+
+	if strings.TrimSpace(cmd.to) == "" {
+		return fmt.Errorf("diff-acl requires a --to URL for the proposed ACL archive")
+	}
+
+	from, err := url.Parse(cmd.from)
+	if err != nil {
+		return fmt.Errorf("invalid ACL file URL '%s' (%w)", cmd.from, err)
+	}
+
+	to, err := url.Parse(cmd.to)
+	if err != nil {
+		return fmt.Errorf("invalid ACL file URL '%s' (%w)", cmd.to, err)
+	}
+
+	if cmd.format != "text" && cmd.format != "json" {
+		return fmt.Errorf("invalid report format '%v' (expected text or json)", cmd.format)
+	}
+
+	conf := config.NewConfig()
+	if cmd.pins, err = loadConfig(cmd.config, conf); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("WARN  Could not load configuration (%v)", err)
+	}
+
+	if cmd.credentials == "" {
+		cmd.credentials = conf.AWS.Credentials
+	}
+
+	if cmd.profile == "" {
+		cmd.profile = conf.AWS.Profile
+	}
+
+	if cmd.region == "" {
+		cmd.region = conf.AWS.Region
+	}
+
+	devices := conf.Devices.ToControllers()
+	if len(devices) == 0 {
+		return fmt.Errorf("diff-acl requires at least one controller in the configuration")
+	}
+
+	return cmd.execute(from.String(), to.String(), devices)
+}
+
+func (cmd *DiffACL) execute(from, to string, devices []uhppote.Device) error {
+	src, current, _, err := cmd.load(from, devices)
+	if err != nil {
+		return err
+	} else if current.delta {
+		return fmt.Errorf("ACL from %v is a delta ACL (expected full ACL)", from)
+	}
+
+	dest, proposed, g, err := cmd.load(to, devices)
+	if err != nil {
+		return err
+	}
+
+	list := proposed.list
+	table := proposed.table
+	if proposed.delta {
+		if list, table, err = cmd.apply(proposed.body, current.list, g, devices); err != nil {
+			return err
+		}
+	}
+
+	compare := acl.Compare
+	if cmd.withPIN {
+		compare = acl.CompareWithPIN
+	}
+
+	diff, err := compare(current.list, list)
+	if err != nil {
+		return err
+	}
+
+	rpt := diffReport{
+		From:  src,
+		To:    dest,
+		Diffs: map[uint32]deviceDiff{},
+	}
+
+	for id, d := range diff {
+		v := deviceDiff{
+			Unchanged: []uint32{},
+			Updated:   d.Updated,
+			Added:     d.Added,
+			Deleted:   d.Deleted,
+		}
+
+		for _, card := range d.Unchanged {
+			v.Unchanged = append(v.Unchanged, card.CardNumber)
+		}
+
+		rpt.Diffs[id] = v
+	}
+
+	// ... deleted cards are only in the --from ACL group memberships
+	if g != nil {
+		m := members{}
+		for _, t := range []*acl.Table{current.table, table} {
+			if t == nil {
+				continue
+			} else if v, err := membership(t); err != nil {
+				return err
+			} else {
+				maps.Copy(m, v)
+			}
+		}
+
+		rpt.Groups = compareGroups(diff, m)
+	}
+
+	if cmd.format == "json" {
+		if b, err := json.MarshalIndent(rpt, "", "  "); err != nil {
+			return err
+		} else {
+			fmt.Printf("%s\n", b)
+		}
+
+		return nil
+	}
+
+	t, err := template.New("diff").Parse(cmd.template)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if err := t.Execute(os.Stdout, rpt); err != nil {
+		return err
+	}
+	fmt.Println()
+
+	return nil
+}
+
+type publishedACL struct {
+	body  []byte
+	list  acl.ACL
+	table *acl.Table
+	delta bool
+}
+
+// Fetches, verifies and parses the ACL archive at the URL. Delta ACLs are returned unparsed since
+// they can only be applied to the --from ACL.
+func (cmd *DiffACL) load(uri string, devices []uhppote.Device) (aclSource, *publishedACL, groups, error) {
+	s := aclSource{
+		URL: uri,
+	}
+
+	f := cmd.fetchHTTP
+	if strings.HasPrefix(uri, "s3://") {
+		f = cmd.fetchS3
+	} else if strings.HasPrefix(uri, "file://") {
+		f = cmd.fetchFile
+	}
+
+	b, err := f(uri)
+	if err != nil {
+		return s, nil, nil, err
+	}
+
+	a, err := unpack(uri, b)
+	if err != nil {
+		return s, nil, nil, err
+	}
+
+	s.ACL = a.name
+
+	if !cmd.noverify {
+		if m, signer, err := a.verify(cmd.keysdir); err != nil {
+			return s, nil, nil, fmt.Errorf("%v: %w", uri, err)
+		} else if err := cmd.pins.check(uri, signer); err != nil {
+			return s, nil, nil, err
+		} else {
+			s.Signer = signer.Name
+			s.KeyID = signer.KeyID
+			if m != nil {
+				s.Sequence = m.Sequence
+			}
+		}
+	}
+
+	body := a.acl
+	if a.encrypted {
+		if body, err = decrypt(a.acl, cmd.sitekey, cmd.sitePassphrase); err != nil {
+			return s, nil, nil, err
+		}
+	}
+
+	g, err := a.groupDefinitions()
+	if err != nil {
+		return s, nil, nil, err
+	}
+
+	if a.delta {
+		return s, &publishedACL{body: body, delta: true}, g, nil
+	}
+
+	format, err := a.format()
+	if err != nil {
+		return s, nil, nil, err
+	}
+
+	list, _, err := parseACL(body, format, g, devices, false)
+	if err != nil {
+		return s, nil, nil, fmt.Errorf("%v: %w", uri, err)
+	}
+
+	p := publishedACL{
+		body: body,
+		list: list,
+	}
+
+	if g != nil {
+		if p.table, err = parseTable(body, format); err != nil {
+			return s, nil, nil, err
+		}
+	}
+
+	return s, &p, g, nil
+}
+
+// Applies a delta ACL to the --from ACL, which must match the delta base ACL. Returns the resulting
+// ACL and the table of added and updated cards (for the card group memberships).
+func (cmd *DiffACL) apply(b []byte, base acl.ACL, g groups, devices []uhppote.Device) (acl.ACL, *acl.Table, error) {
+	d, err := parseDelta(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if digest, err := aclDigest(base, devices); err != nil {
+		return nil, nil, err
+	} else if digest != d.Base {
+		return nil, nil, fmt.Errorf("--from ACL does not match the delta base ACL (SHA-256 %v, expected %v)", digest, d.Base)
+	}
+
+	list, _, err := d.apply(base, g, devices)
+
+	return list, cardsTable(slices.Concat(d.Add, d.Update)), err
+}
+
+func (cmd *DiffACL) fetchHTTP(url string) ([]byte, error) {
+	return fetchHTTP(url)
+}
+
+func (cmd *DiffACL) fetchS3(url string) ([]byte, error) {
+	return fetchS3(url, cmd.credentials, cmd.profile, cmd.region)
+}
+
+func (cmd *DiffACL) fetchFile(url string) ([]byte, error) {
+	return fetchFile(url)
+}
@@ -0,0 +1,235 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/uhppoted/uhppote-core/uhppote"
+	"github.com/uhppoted/uhppoted-lib/acl"
+)
+
+// ACL file formats, identified by the ACL file extension (or the signed manifest 'format').
+const (
+	formatTSV  = "tsv"
+	formatCSV  = "csv"
+	formatJSON = "json"
+	formatXLSX = "xlsx"
+)
+
+var extensions = map[string]string{
+	formatTSV:  ".acl",
+	formatCSV:  ".csv",
+	formatJSON: ".json",
+	formatXLSX: ".xlsx",
+}
+
+// Returns the ACL file format for the file extension, ignoring the .enc extension of encrypted ACL files.
+func aclFormat(filename string) string {
+	ext := filepath.Ext(strings.TrimSuffix(filename, ".enc"))
+	for format, v := range extensions {
+		if ext == v {
+			return format
+		}
+	}
+
+	return ""
+}
+
+func isACL(filename string) bool {
+	return aclFormat(filename) != ""
+}
+
+// ACL file format, with the CSV delimiter (CSV files only) and worksheet name (XLSX files only).
+type fileFormat struct {
+	name      string
+	delimiter string
+	sheet     string
+}
+
+// Returns the ACL file format for the archive ACL file, from the manifest if the manifest specifies
+// the format and otherwise from the ACL file extension.
+func (a *archive) format() (fileFormat, error) {
+	f := fileFormat{
+		name: aclFormat(a.name),
+	}
+
+	if a.manifest != nil {
+		if m, err := parseManifest(a.manifest); err != nil {
+			return f, err
+		} else {
+			if m.Format != "" {
+				f.name = m.Format
+			}
+
+			f.delimiter = m.Delimiter
+			f.sheet = m.Sheet
+		}
+	}
+
+	return f, nil
+}
+
+// Parses an ACL file in the specified format, expanding the card groups (if any) to the door
+// permissions.
+func parseACL(b []byte, format fileFormat, g groups, devices []uhppote.Device, strict bool) (acl.ACL, []error, error) {
+	table, err := parseTable(b, format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	table = withoutHolder(table)
+
+	if g != nil {
+		if table, err = g.expand(table); err != nil {
+			return nil, nil, err
+		}
+	} else if groupsColumn(table.Header) >= 0 {
+		return nil, nil, fmt.Errorf("ACL file has a 'Groups' column but no group definitions")
+	}
+
+	list, warnings, err := acl.ParseTable(table, devices, strict)
+	if err != nil {
+		return nil, nil, err
+	} else if list == nil {
+		return nil, nil, fmt.Errorf("invalid %v ACL file", strings.ToUpper(format.name))
+	}
+
+	return *list, warnings, nil
+}
+
+// Parses an ACL file in the specified format as an unvalidated ACL table.
+func parseTable(b []byte, format fileFormat) (*acl.Table, error) {
+	switch format.name {
+	case formatTSV:
+		return parseCSV(b, "tab")
+
+	case formatCSV:
+		return parseCSV(b, format.delimiter)
+
+	case formatJSON:
+		return parseJSON(b)
+
+	case formatXLSX:
+		return parseXLSX(b, format.sheet)
+
+	default:
+		return nil, fmt.Errorf("unsupported ACL file format '%v'", format.name)
+	}
+}
+
+// Generates an ACL file in the specified format from the ACL retrieved from the controllers.
+func makeACL(list acl.ACL, devices []uhppote.Device, format string, withPIN bool) ([]byte, error) {
+	var table *acl.Table
+	var err error
+
+	if withPIN {
+		table, err = acl.MakeTableWithPIN(list, devices)
+	} else {
+		table, err = acl.MakeTable(list, devices)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return makeFile(table, fileFormat{name: format})
+}
+
+// Generates an ACL file in the specified format from an ACL table, retaining the column order and
+// any 'Groups' column.
+func makeFile(table *acl.Table, format fileFormat) ([]byte, error) {
+	switch format.name {
+	case formatTSV:
+		return makeCSV(table, "tab")
+
+	case formatCSV:
+		return makeCSV(table, format.delimiter)
+
+	case formatJSON:
+		return makeJSON(table)
+
+	case formatXLSX:
+		return makeXLSX(table, format.sheet)
+
+	default:
+		return nil, fmt.Errorf("unsupported ACL file format '%v'", format.name)
+	}
+}
+
+// Parses an RFC 4180 CSV ACL file with the same header conventions as the TSV format.
+func parseCSV(b []byte, delimiter string) (*acl.Table, error) {
+	comma, err := parseDelimiter(delimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(bytes.NewReader(b))
+	r.Comma = comma
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header (%w)", err)
+	}
+
+	table := acl.Table{
+		Header:  header,
+		Records: [][]string{},
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error parsing CSV (%w)", err)
+		}
+
+		table.Records = append(table.Records, record)
+	}
+
+	return &table, nil
+}
+
+func parseDelimiter(delimiter string) (rune, error) {
+	switch delimiter {
+	case "":
+		return ',', nil
+
+	case "tab", "\\t":
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(delimiter)
+	if size != len(delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid CSV delimiter '%v'", delimiter)
+	}
+
+	return r, nil
+}
+
+func makeCSV(table *acl.Table, delimiter string) ([]byte, error) {
+	comma, err := parseDelimiter(delimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	w := csv.NewWriter(&b)
+	w.Comma = comma
+
+	if err := w.Write(table.Header); err != nil {
+		return nil, err
+	}
+
+	if err := w.WriteAll(table.Records); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns a channel that receives the names of files that are written or moved into the directory,
+// using inotify.
+func watchFiles(ctx context.Context, dir string) (<-chan string, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := unix.InotifyAddWatch(fd, dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "inotify")
+	ch := make(chan string, 16)
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	go func() {
+		defer close(ch)
+
+		buffer := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			N, err := f.Read(buffer)
+			if err != nil {
+				return
+			}
+
+			for offset := 0; offset+unix.SizeofInotifyEvent <= N; {
+				event := (*unix.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				name := buffer[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+
+				offset += unix.SizeofInotifyEvent + int(event.Len)
+
+				if event.Len > 0 {
+					select {
+					case ch <- string(bytes.TrimRight(name, "\x00")):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Filesystem notifications are only supported on Linux - other platforms fall back to polling.
+func watchFiles(ctx context.Context, dir string) (<-chan string, error) {
+	return nil, fmt.Errorf("filesystem notifications are not supported on %v", runtime.GOOS)
+}
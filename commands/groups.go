@@ -0,0 +1,285 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uhppoted/uhppoted-lib/acl"
+)
+
+// Signed group definition for ACL files that assign cards to named groups (in a 'Groups' column)
+// rather than (or as well as) listing the permissions for each door, e.g.
+//
+//	{
+//	  "groups": {
+//	    "staff":    { "Front Door": true, "Side Door": true, "Garage": 29 },
+//	    "cleaners": { "Front Door": 29, "Side Door": 29 }
+//	  }
+//	}
+//
+// The group definition is included in the signed archive as the 'groups' file and is expanded to
+// the per-door permissions before the ACL is loaded, with a 'Y' taking precedence over a time
+// profile for cards in more than one group.
+type groups map[string]map[string]permission
+
+// Card group memberships, from the ACL file 'Groups' column.
+type members map[uint32][]string
+
+type GroupDiff struct {
+	Unchanged []uint32 `json:"unchanged"`
+	Updated   []uint32 `json:"updated"`
+	Added     []uint32 `json:"added"`
+	Deleted   []uint32 `json:"deleted"`
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// Returns the group definitions from the archive 'groups' file (if any).
+func (a *archive) groupDefinitions() (groups, error) {
+	if a.groups == nil {
+		return nil, nil
+	}
+
+	return parseGroups(a.groups)
+}
+
+func parseGroups(b []byte) (groups, error) {
+	var doc struct {
+		Groups groups `json:"groups"`
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid groups file (%w)", err)
+	} else if len(doc.Groups) == 0 {
+		return nil, fmt.Errorf("invalid groups file (no groups)")
+	}
+
+	for name, doors := range doc.Groups {
+		for door, p := range doors {
+			if p != "Y" && p != "N" {
+				if profile, err := strconv.Atoi(string(p)); err != nil || profile < 2 || profile > 254 {
+					return nil, fmt.Errorf("invalid permission '%v' for door '%v' in group '%v'", p, door, name)
+				}
+			}
+		}
+	}
+
+	return doc.Groups, nil
+}
+
+// Replaces the ACL table 'Groups' column with the door permissions for the groups, merged with
+// any explicit door permissions.
+func (g groups) expand(table *acl.Table) (*acl.Table, error) {
+	column := groupsColumn(table.Header)
+	if column < 0 {
+		return table, nil
+	}
+
+	header := slices.Delete(slices.Clone(table.Header), column, column+1)
+	doors := map[string]int{}
+	for i, h := range header {
+		switch normalise(h) {
+		case "cardnumber", "pin", "from", "to":
+		default:
+			doors[normalise(h)] = i
+		}
+	}
+
+	names := []string{}
+	for name := range g {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	added := 0
+	for _, name := range names {
+		list := []string{}
+		for door := range g[name] {
+			list = append(list, door)
+		}
+
+		sort.Strings(list)
+
+		for _, door := range list {
+			if _, ok := doors[normalise(door)]; !ok {
+				doors[normalise(door)] = len(header)
+				header = append(header, door)
+				added++
+			}
+		}
+	}
+
+	expanded := acl.Table{
+		Header:  header,
+		Records: [][]string{},
+	}
+
+	for row, r := range table.Records {
+		record := make([]string, 0, len(header))
+		for i := range table.Header {
+			if i != column {
+				record = append(record, field(r, i))
+			}
+		}
+
+		for range added {
+			record = append(record, "N")
+		}
+
+		for _, name := range split(field(r, column)) {
+			permissions, ok := g[name]
+			if !ok {
+				return nil, fmt.Errorf("row %v: unknown group '%v'", row+1, name)
+			}
+
+			for door, p := range permissions {
+				ix := doors[normalise(door)]
+				if v, err := merge(record[ix], string(p)); err != nil {
+					return nil, fmt.Errorf("row %v: %w for door '%v'", row+1, err, door)
+				} else {
+					record[ix] = v
+				}
+			}
+		}
+
+		expanded.Records = append(expanded.Records, record)
+	}
+
+	return &expanded, nil
+}
+
+// Returns the card group memberships from the ACL table 'Groups' column.
+func membership(table *acl.Table) (members, error) {
+	m := members{}
+
+	column := groupsColumn(table.Header)
+	if column < 0 {
+		return m, nil
+	}
+
+	card := slices.IndexFunc(table.Header, func(h string) bool { return normalise(h) == "cardnumber" })
+	if card < 0 {
+		return nil, fmt.Errorf("missing 'Card Number' column")
+	}
+
+	for _, record := range table.Records {
+		if v, err := strconv.ParseUint(strings.TrimSpace(field(record, card)), 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid card number '%v'", field(record, card))
+		} else {
+			m[uint32(v)] = split(field(record, column))
+		}
+	}
+
+	return m, nil
+}
+
+// Summarises the ACL differences in group terms i.e. for each group the cards that are unchanged,
+// incorrect (updated), missing (added) or unexpected (deleted) on any controller.
+func compareGroups(diff map[uint32]acl.Diff, m members) map[string]GroupDiff {
+	updated := map[uint32]bool{}
+	added := map[uint32]bool{}
+	deleted := map[uint32]bool{}
+
+	for _, d := range diff {
+		for _, card := range d.Updated {
+			updated[card.CardNumber] = true
+		}
+
+		for _, card := range d.Added {
+			added[card.CardNumber] = true
+		}
+
+		for _, card := range d.Deleted {
+			deleted[card.CardNumber] = true
+		}
+	}
+
+	list := map[string]GroupDiff{}
+	for card, names := range m {
+		for _, name := range names {
+			g := list[name]
+
+			switch {
+			case updated[card]:
+				g.Updated = append(g.Updated, card)
+			case added[card]:
+				g.Added = append(g.Added, card)
+			case deleted[card]:
+				g.Deleted = append(g.Deleted, card)
+			default:
+				g.Unchanged = append(g.Unchanged, card)
+			}
+
+			list[name] = g
+		}
+	}
+
+	for name, g := range list {
+		slices.Sort(g.Unchanged)
+		slices.Sort(g.Updated)
+		slices.Sort(g.Added)
+		slices.Sort(g.Deleted)
+
+		list[name] = g
+	}
+
+	return list
+}
+
+// Merges a group door permission with the current door permission, with 'Y' taking precedence over
+// a time profile.
+func merge(current, p string) (string, error) {
+	current = strings.ToUpper(strings.TrimSpace(current))
+
+	switch {
+	case current == "" || current == "N":
+		return p, nil
+
+	case current == "Y" || p == "N" || p == current:
+		return current, nil
+
+	case p == "Y":
+		return p, nil
+
+	default:
+		return "", fmt.Errorf("conflicting time profiles (%v and %v)", current, p)
+	}
+}
+
+func groupsColumn(header []string) int {
+	return slices.IndexFunc(header, func(h string) bool { return normalise(h) == "groups" })
+}
+
+func split(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if name := strings.TrimSpace(v); name != "" {
+			list = append(list, name)
+		}
+	}
+
+	return list
+}
+
+func field(record []string, ix int) string {
+	if ix < len(record) {
+		return record[ix]
+	}
+
+	return ""
+}
+
+// Matches the uhppoted-lib ACL header normalisation i.e. lowercase with whitespace removed.
+func normalise(s string) string {
+	return strings.ToLower(whitespace.ReplaceAllString(s, ""))
+}
@@ -0,0 +1,288 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/uhppoted/uhppoted-lib/acl"
+)
+
+// JSON ACL file format, e.g.
+//
+//	{
+//	  "cards": [
+//	    {
+//	      "card": 10058400,
+//	      "PIN": 7531,
+//	      "from": "2026-01-01",
+//	      "to": "2026-12-31",
+//	      "doors": { "Front Door": true, "Side Door": false, "Garage": 29 },
+//	      "holder": { "name": "Hermione Granger", "department": "Gryffindor" }
+//	    }
+//	  ]
+//	}
+//
+// Door permissions are true/false (or "Y"/"N") or a time profile ID, with omitted doors defaulting to
+// no access. Cards may also be assigned to groups (e.g. "groups": [ "staff" ]) defined in the signed
+// 'groups' file. The card holder metadata is free-form and is not stored on the controllers - in the
+// TSV, CSV and XLSX formats it is carried in 'Holder.<field>' columns (e.g. 'Holder.name').
+type jsonACL struct {
+	Cards []jsonCard `json:"cards"`
+}
+
+type jsonCard struct {
+	Card   uint32                `json:"card"`
+	PIN    uint32                `json:"PIN,omitempty"`
+	From   string                `json:"from"`
+	To     string                `json:"to"`
+	Doors  map[string]permission `json:"doors"`
+	Groups []string              `json:"groups,omitempty"`
+	Holder map[string]string     `json:"holder,omitempty"`
+}
+
+const holderPrefix = "Holder."
+
+// Door permission as a TSV 'Y', 'N' or time profile ID.
+type permission string
+
+func (p permission) MarshalJSON() ([]byte, error) {
+	switch p {
+	case "Y":
+		return json.Marshal(true)
+
+	case "N", "":
+		return json.Marshal(false)
+	}
+
+	if profile, err := strconv.Atoi(string(p)); err == nil {
+		return json.Marshal(profile)
+	}
+
+	return json.Marshal(string(p))
+}
+
+func (p *permission) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case bool:
+		if value {
+			*p = "Y"
+		} else {
+			*p = "N"
+		}
+
+	case float64:
+		*p = permission(fmt.Sprintf("%v", value))
+
+	case string:
+		*p = permission(strings.ToUpper(strings.TrimSpace(value)))
+
+	default:
+		return fmt.Errorf("invalid door permission %s", b)
+	}
+
+	return nil
+}
+
+func parseJSON(b []byte) (*acl.Table, error) {
+	var doc jsonACL
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON ACL file (%w)", err)
+	}
+
+	return cardsTable(doc.Cards), nil
+}
+
+// Converts a list of JSON cards to an ACL table with a column for each door (and a 'Groups' column
+// if any of the cards are assigned to groups and a 'Holder.<field>' column for each card holder field).
+func cardsTable(cards []jsonCard) *acl.Table {
+	table := acl.Table{
+		Header:  []string{"Card Number", "PIN", "From", "To"},
+		Records: [][]string{},
+	}
+
+	if slices.ContainsFunc(cards, func(c jsonCard) bool { return len(c.Groups) > 0 }) {
+		table.Header = append(table.Header, "Groups")
+	}
+
+	// ... holder and door columns in sorted order (card holder fields and door permissions are unordered maps)
+	holders := len(table.Header)
+	fields := map[string]int{}
+	for _, card := range cards {
+		for k := range card.Holder {
+			fields[k] = 0
+		}
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(fields)) {
+		fields[k] = len(table.Header)
+		table.Header = append(table.Header, holderPrefix+k)
+	}
+
+	doors := len(table.Header)
+	columns := map[string]int{}
+	for _, card := range cards {
+		for door := range card.Doors {
+			columns[door] = 0
+		}
+	}
+
+	for _, door := range slices.Sorted(maps.Keys(columns)) {
+		columns[door] = len(table.Header)
+		table.Header = append(table.Header, door)
+	}
+
+	for _, card := range cards {
+		record := make([]string, len(table.Header))
+
+		record[0] = fmt.Sprintf("%v", card.Card)
+		record[2] = card.From
+		record[3] = card.To
+
+		if card.PIN != 0 {
+			record[1] = fmt.Sprintf("%v", card.PIN)
+		}
+
+		if holders > 4 {
+			record[4] = strings.Join(card.Groups, ",")
+		}
+
+		for k, v := range card.Holder {
+			record[fields[k]] = v
+		}
+
+		for i := doors; i < len(record); i++ {
+			record[i] = "N"
+		}
+
+		for door, p := range card.Doors {
+			record[columns[door]] = string(p)
+		}
+
+		table.Records = append(table.Records, record)
+	}
+
+	return &table
+}
+
+// Converts an ACL table to a JSON ACL file. Empty PIN fields are omitted, a 'Groups' column is
+// converted to the card groups list and 'Holder.<field>' columns to the card holder metadata.
+func makeJSON(table *acl.Table) ([]byte, error) {
+	doc := jsonACL{
+		Cards: []jsonCard{},
+	}
+
+	for _, record := range table.Records {
+		card := jsonCard{
+			Doors: map[string]permission{},
+		}
+
+		for i, h := range table.Header {
+			value := strings.TrimSpace(field(record, i))
+
+			if k, ok := holderField(h); ok {
+				if value != "" {
+					if card.Holder == nil {
+						card.Holder = map[string]string{}
+					}
+
+					card.Holder[k] = value
+				}
+
+				continue
+			}
+
+			switch normalise(h) {
+			case "cardnumber":
+				if v, err := strconv.ParseUint(value, 10, 32); err != nil {
+					return nil, fmt.Errorf("invalid card number '%v'", value)
+				} else {
+					card.Card = uint32(v)
+				}
+
+			case "pin":
+				if value == "" {
+					continue
+				} else if v, err := strconv.ParseUint(value, 10, 32); err != nil {
+					return nil, fmt.Errorf("invalid card PIN '%v'", value)
+				} else {
+					card.PIN = uint32(v)
+				}
+
+			case "from":
+				card.From = value
+
+			case "to":
+				card.To = value
+
+			case "groups":
+				card.Groups = split(value)
+
+			default:
+				card.Doors[h] = permission(strings.ToUpper(value))
+			}
+		}
+
+		doc.Cards = append(doc.Cards, card)
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// Returns the card holder metadata field name for a 'Holder.<field>' column.
+func holderField(h string) (string, bool) {
+	h = strings.TrimSpace(h)
+	if len(h) > len(holderPrefix) && strings.EqualFold(h[:len(holderPrefix)], holderPrefix) {
+		return h[len(holderPrefix):], true
+	}
+
+	return "", false
+}
+
+// Returns the ACL table without the 'Holder.<field>' card holder metadata columns, which are not
+// stored on the controllers.
+func withoutHolder(table *acl.Table) *acl.Table {
+	columns := []int{}
+	for i, h := range table.Header {
+		if _, ok := holderField(h); !ok {
+			columns = append(columns, i)
+		}
+	}
+
+	if len(columns) == len(table.Header) {
+		return table
+	}
+
+	t := acl.Table{
+		Header:  []string{},
+		Records: [][]string{},
+	}
+
+	for _, i := range columns {
+		t.Header = append(t.Header, table.Header[i])
+	}
+
+	for _, r := range table.Records {
+		record := make([]string, 0, len(columns))
+		for _, i := range columns {
+			record = append(record, field(r, i))
+		}
+
+		t.Records = append(t.Records, record)
+	}
+
+	return &t
+}
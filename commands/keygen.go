@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/uhppoted/uhppoted-app-s3/auth"
+)
+
+var KeyGenCmd = KeyGen{
+	dir:        ".",
+	bits:       2048,
+	passphrase: "",
+}
+
+type KeyGen struct {
+	uname      string
+	dir        string
+	bits       int
+	passphrase string
+}
+
+func (cmd *KeyGen) Name() string {
+	return "keygen"
+}
+
+func (cmd *KeyGen) FlagSet() *flag.FlagSet {
+	flagset := flag.NewFlagSet("keygen", flag.ExitOnError)
+
+	flagset.StringVar(&cmd.uname, "uname", cmd.uname, "User ID for the signing key. The keys are written to the files '<uname>' and '<uname>.pub'")
+	flagset.StringVar(&cmd.dir, "dir", cmd.dir, "Directory for the generated key files (defaults to the current directory)")
+	flagset.IntVar(&cmd.bits, "bits", cmd.bits, "RSA key size (defaults to 2048)")
+	flagset.StringVar(&cmd.passphrase, "passphrase", cmd.passphrase, "Passphrase with which to encrypt the private key, specified as file:<path>, env:<variable> or credential:<systemd credential>")
+
+	return flagset
+}
+
+func (cmd *KeyGen) Description() string {
+	return "Generates an RSA key pair for signing ACL files"
+}
+
+func (cmd *KeyGen) Usage() string {
+	return "keygen --uname <user ID>"
+}
+
+func (cmd *KeyGen) Help() {
+	fmt.Println()
+	fmt.Printf("  Usage: %s keygen --uname <user ID> [--dir <dir>] [--bits <bits>] [--passphrase <source>]\n", APP)
+	fmt.Println()
+	fmt.Println("    Generates an RSA key pair for signing ACL files. The private key is written to the file <uname> and")
+	fmt.Println("    the public key to the file <uname>.pub, which should be copied to the keys directory of the systems")
+	fmt.Println("    that load the signed ACL files.")
+	fmt.Println()
+
+	helpOptions(cmd.FlagSet())
+	fmt.Println()
+}
+
+func (cmd *KeyGen) Execute(args ...interface{}) error {
+	uname := strings.TrimSpace(cmd.uname)
+	if uname == "" {
+		return fmt.Errorf("keygen requires a user ID for the signing key")
+	} else if filepath.Base(uname) != uname {
+		return fmt.Errorf("invalid user ID '%v'", uname)
+	}
+
+	keyfile := filepath.Join(cmd.dir, uname)
+	pubfile := filepath.Join(cmd.dir, uname+".pub")
+
+	for _, f := range []string{keyfile, pubfile} {
+		if _, err := os.Stat(f); err == nil {
+			return fmt.Errorf("%v already exists", f)
+		}
+	}
+
+	key, pubkey, err := auth.GenerateKey(cmd.bits, cmd.passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyfile, key, 0600); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(pubfile, pubkey, 0644); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("  Created RSA signing key %v\n", keyfile)
+	fmt.Printf("  Created RSA public key  %v\n", pubfile)
+	fmt.Println()
+
+	return nil
+}
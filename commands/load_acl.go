@@ -1,13 +1,16 @@
 package commands
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	syslog "log"
+	"maps"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,26 +20,30 @@ import (
 	"github.com/uhppoted/uhppoted-lib/eventlog"
 	"github.com/uhppoted/uhppoted-lib/lockfile"
 
+	"github.com/uhppoted/uhppoted-app-s3/auth"
 	"github.com/uhppoted/uhppoted-app-s3/log"
 )
 
 var LoadACLCmd = LoadACL{
-	config:      config.DefaultConfig,
-	workdir:     DEFAULT_WORKDIR,
-	keysdir:     DEFAULT_KEYSDIR,
-	credentials: DEFAULT_CREDENTIALS,
-	profile:     DEFAULT_PROFILE,
-	region:      DEFAULT_REGION,
-	logFile:     DEFAULT_LOGFILE,
-	logFileSize: DEFAULT_LOGFILESIZE,
-	withPIN:     false,
-	dryrun:      false,
-	strict:      false,
-	noreport:    false,
-	noverify:    false,
-	nolog:       false,
-	debug:       false,
-	template: `ACL DIFF REPORT {{ .DateTime }}
+	config:         config.DefaultConfig,
+	workdir:        DEFAULT_WORKDIR,
+	keysdir:        DEFAULT_KEYSDIR,
+	sitekey:        DEFAULT_SITEKEY,
+	credentials:    DEFAULT_CREDENTIALS,
+	profile:        DEFAULT_PROFILE,
+	region:         DEFAULT_REGION,
+	logFile:        DEFAULT_LOGFILE,
+	logFileSize:    DEFAULT_LOGFILESIZE,
+	withPIN:        false,
+	dryrun:         false,
+	strict:         false,
+	noreport:       false,
+	noverify:       false,
+	allowDowngrade: false,
+	nolog:          false,
+	debug:          false,
+	template: `ACL DIFF REPORT {{ .DateTime }}{{if .Signer}}
+  SIGNED BY {{ .Signer }}{{end}}
 {{range $id,$value := .Diffs}}
   DEVICE {{ $id }}{{if $value.Unchanged}}
     Unchanged: {{range $value.Unchanged}}{{.}}
@@ -46,28 +53,46 @@ var LoadACLCmd = LoadACL{
     Added:     {{range $value.Added}}{{.}}
                {{end}}{{end}}{{if $value.Deleted}}
     Deleted:   {{range $value.Deleted}}{{.}}
-               {{end}}{{end}}{{end}}
+               {{end}}{{end}}{{end}}{{if .Groups}}
+{{range $group,$value := .Groups}}
+  GROUP {{ $group }}{{if $value.Unchanged}}
+    Unchanged: {{range $value.Unchanged}}{{.}}
+               {{end}}{{end}}{{if $value.Updated}}
+    Updated:   {{range $value.Updated}}{{.}}
+               {{end}}{{end}}{{if $value.Added}}
+    Added:     {{range $value.Added}}{{.}}
+               {{end}}{{end}}{{if $value.Deleted}}
+    Deleted:   {{range $value.Deleted}}{{.}}
+               {{end}}{{end}}{{end}}{{end}}
 `,
 }
 
 type LoadACL struct {
-	url         string
-	config      string
-	workdir     string
-	keysdir     string
-	credentials string
-	profile     string
-	region      string
-	logFile     string
-	logFileSize int
-	template    string
-	withPIN     bool
-	dryrun      bool
-	strict      bool
-	noreport    bool
-	noverify    bool
-	nolog       bool
-	debug       bool
+	url            string
+	config         string
+	workdir        string
+	keysdir        string
+	sitekey        string
+	sitePassphrase string
+	credentials    string
+	profile        string
+	region         string
+	logFile        string
+	logFileSize    int
+	template       string
+	latest         string
+	withPIN        bool
+	dryrun         bool
+	strict         bool
+	noreport       bool
+	noverify       bool
+	allowDowngrade bool
+	pins           pins
+	source         string
+	pointer        *pointer
+	delta          *manifest
+	nolog          bool
+	debug          bool
 }
 
 func (cmd *LoadACL) Name() string {
@@ -78,13 +103,17 @@ func (cmd *LoadACL) FlagSet() *flag.FlagSet {
 	flagset := flag.NewFlagSet("load-acl", flag.ExitOnError)
 
 	flagset.StringVar(&cmd.url, "url", cmd.url, "The URL from which to fetch the ACL file")
+	flagset.StringVar(&cmd.latest, "latest", cmd.latest, "Treats the URL as a prefix and loads the newest valid ACL by object 'key' or 'modified' time")
 	flagset.StringVar(&cmd.credentials, "credentials", cmd.credentials, "AWS credentials file")
 	flagset.StringVar(&cmd.profile, "profile", cmd.profile, "AWS credentials file profile (defaults to 'default')")
 	flagset.StringVar(&cmd.region, "region", cmd.region, "AWS region for S3 (defaults to us-east-1)")
 	flagset.StringVar(&cmd.keysdir, "keys", cmd.keysdir, "Sets the directory to search for RSA signing keys. Key files are expected to be named '<uname>.pub'")
+	flagset.StringVar(&cmd.sitekey, "site-key", cmd.sitekey, "RSA private key for decrypting encrypted ACL files")
+	flagset.StringVar(&cmd.sitePassphrase, "site-key-passphrase", cmd.sitePassphrase, "Passphrase for an encrypted site private key, specified as file:<path>, env:<variable> or credential:<systemd credential>")
 	flagset.StringVar(&cmd.workdir, "workdir", cmd.workdir, "Sets the working directory for temporary files, etc")
 	flagset.BoolVar(&cmd.withPIN, "with-pin", cmd.withPIN, "Includes the card keypad PIN codes when updating the controllers")
 	flagset.BoolVar(&cmd.noverify, "no-verify", cmd.noverify, "Disables verification of the downloaded ACL RSA signature")
+	flagset.BoolVar(&cmd.allowDowngrade, "allow-downgrade", cmd.allowDowngrade, "Allows loading a signed ACL that is older than the last ACL loaded from the same URL")
 	flagset.BoolVar(&cmd.dryrun, "dry-run", cmd.dryrun, "Simulates a load-acl without making any changes to the access controllers")
 	flagset.BoolVar(&cmd.strict, "strict", cmd.strict, "Fails the load if the ACL contains duplicate card numbers")
 	flagset.BoolVar(&cmd.noreport, "no-report", cmd.noreport, "Disables ACL 'diff' report")
@@ -103,11 +132,20 @@ func (cmd *LoadACL) Usage() string {
 
 func (cmd *LoadACL) Help() {
 	fmt.Println()
-	fmt.Printf("  Usage: %s [--debug] [--config <file>] load-acl --url <URL> [--dry-run] [--credentials <file>] [--profile <file>] [--region <region>] [--keys <dir>] [--workdir <dir>] [--strict] [--no-verify] [--no-log] [--no-report]\n", APP)
+	fmt.Printf("  Usage: %s [--debug] [--config <file>] load-acl --url <URL> [--latest key|modified] [--dry-run] [--credentials <file>] [--profile <file>] [--region <region>] [--keys <dir>] [--site-key <file>] [--site-key-passphrase <source>] [--workdir <dir>] [--strict] [--no-verify] [--allow-downgrade] [--no-log] [--no-report]\n", APP)
 	fmt.Println()
 	fmt.Println("    Fetches the ACL file stored at the pre-signed S3 URL and loads it to the controllers configured in")
 	fmt.Println("    the configuration file. Duplicate card numbers are ignored (or deleted if they exist) with a warning")
-	fmt.Println("    unless the --strict option is specified")
+	fmt.Println("    unless the --strict option is specified. A signed ACL that is older than the last ACL loaded from the same URL")
+	fmt.Println("    (by manifest sequence number and issue date) is rejected unless the --allow-downgrade option is specified.")
+	fmt.Println("    A delta ACL is applied only if the controllers match the delta base ACL and otherwise the full ACL is loaded.")
+	fmt.Println()
+	fmt.Println("    The --latest option treats an s3:// or file:// URL as a prefix and loads the newest ACL archive with that")
+	fmt.Println("    prefix, ordered by object key (e.g. for timestamped keys) or by modification time. Candidates are verified")
+	fmt.Println("    newest first and invalid ACL archives are skipped with a warning.")
+	fmt.Println()
+	fmt.Println("    A URL ending in .json is a signed ACL pointer (e.g. latest.json) that references the ACL archive to load by")
+	fmt.Println("    URL, SHA-256 digest and sequence number.")
 	fmt.Println()
 
 	helpOptions(cmd.FlagSet())
@@ -130,8 +168,14 @@ func (cmd *LoadACL) Execute(args ...interface{}) error {
 		return fmt.Errorf("invalid ACL file URL '%s' (%w)", cmd.url, err)
 	}
 
+	if cmd.latest != "" && cmd.latest != "key" && cmd.latest != "modified" {
+		return fmt.Errorf("invalid --latest order '%v' (expected 'key' or 'modified')", cmd.latest)
+	} else if cmd.latest != "" && uri.Scheme != "s3" && uri.Scheme != "file" {
+		return fmt.Errorf("--latest requires an s3:// or file:// URL prefix")
+	}
+
 	conf := config.NewConfig()
-	if err := conf.Load(cmd.config); err != nil {
+	if cmd.pins, err = loadConfig(cmd.config, conf); err != nil {
 		return fmt.Errorf("WARN  Could not load configuration (%v)", err)
 	}
 
@@ -175,51 +219,142 @@ func (cmd *LoadACL) Execute(args ...interface{}) error {
 }
 
 func (cmd *LoadACL) execute(u uhppote.IUHPPOTE, uri string, devices []uhppote.Device) error {
-	log.Infof("Fetching ACL from %v", uri)
+	if cmd.latest != "" {
+		return cmd.loadLatest(u, uri, devices)
+	} else if isPointer(uri) {
+		return cmd.loadPointer(u, uri, devices)
+	}
 
-	f := cmd.fetchHTTP
-	if strings.HasPrefix(uri, "s3://") {
-		f = cmd.fetchS3
-	} else if strings.HasPrefix(uri, "file://") {
-		f = cmd.fetchFile
+	return cmd.load(u, uri, devices, true)
+}
+
+// Fetches and verifies the ACL pointer and loads the ACL archive that it references, which must match
+// the pointer SHA-256 digest and sequence number. The replay and downgrade protection state is kept
+// for the pointer URL.
+func (cmd *LoadACL) loadPointer(u uhppote.IUHPPOTE, uri string, devices []uhppote.Device) error {
+	log.Infof("Fetching ACL pointer from %v", uri)
+
+	b, err := cmd.fetch(uri)
+	if err != nil {
+		return err
 	}
 
-	b, err := f(uri)
+	p, signed, signature, err := parsePointer(b)
 	if err != nil {
 		return err
 	}
 
-	log.Infof("Fetched ACL from %v (%d bytes)", uri, len(b))
+	if !cmd.noverify {
+		if len(signature) == 0 {
+			return fmt.Errorf("ACL pointer %v is not signed", uri)
+		}
+
+		signer, err := verify("", signed, signature, cmd.keysdir)
+		if err != nil {
+			return fmt.Errorf("invalid ACL pointer signature (%w)", err)
+		}
 
-	x := untar
-	if strings.HasSuffix(uri, ".zip") {
-		x = unzip
+		log.Infof("Verified ACL pointer from %v signed by %v", uri, signer)
+
+		if err := cmd.pins.check(uri, signer); err != nil {
+			log.Warnf("SECURITY  %v", err)
+			return err
+		}
 	}
 
-	files, uname, err := x(bytes.NewReader(b))
+	acl, err := p.resolve(uri)
 	if err != nil {
 		return err
 	}
 
-	tsv, ok := files["ACL"]
-	if !ok {
-		return fmt.Errorf("ACL file missing from tar.gz")
+	log.Infof("ACL pointer %v references %v (%v)", uri, acl, p)
+
+	cmd.pointer = p
+	if cmd.source == "" {
+		cmd.source = uri
 	}
 
-	signature, ok := files["signature"]
-	if !cmd.noverify && !ok {
-		return fmt.Errorf("'signature' file missing from tar.gz")
+	return cmd.load(u, acl, devices, true)
+}
+
+// Loads the newest valid ACL archive with the URL prefix. The candidates are verified newest first,
+// skipping any that cannot be fetched or verified, and the first valid ACL archive is loaded. The
+// replay and downgrade protection state is kept for the prefix rather than for the individual ACLs.
+func (cmd *LoadACL) loadLatest(u uhppote.IUHPPOTE, prefix string, devices []uhppote.Device) error {
+	list := func() ([]stored, error) {
+		if strings.HasPrefix(prefix, "s3://") {
+			return listS3(prefix, cmd.credentials, cmd.profile, cmd.region)
+		}
+
+		return listFile(prefix)
 	}
 
-	log.Infof("Extracted ACL from %v: %v bytes, signature: %v bytes", uri, len(tsv), len(signature))
+	objects, err := list()
+	if err != nil {
+		return err
+	}
 
-	if !cmd.noverify {
-		if err := verify(uname, tsv, signature, cmd.keysdir); err != nil {
-			return err
+	candidates := []stored{}
+	for _, object := range objects {
+		if isArchive(path.Base(object.key)) {
+			candidates = append(candidates, object)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		p, q := candidates[i], candidates[j]
+		if cmd.latest == "modified" && !p.modified.Equal(q.modified) {
+			return p.modified.After(q.modified)
+		}
+
+		return p.key > q.key
+	})
+
+	log.Infof("Found %v ACL archives with prefix %v", len(candidates), prefix)
+
+	if cmd.source == "" {
+		cmd.source = prefix
+	}
+
+	for _, c := range candidates {
+		if err := cmd.check(c.url, devices); err != nil {
+			log.Warnf("Skipping ACL from %v (%v)", c.url, err)
+			continue
 		}
+
+		log.Infof("Latest valid ACL with prefix %v is %v", prefix, c.url)
+
+		return cmd.load(u, c.url, devices, true)
+	}
+
+	return fmt.Errorf("no valid ACL with prefix %v", prefix)
+}
+
+// Fetches, verifies and loads the ACL from the URL. A delta ACL is only applied if the controllers
+// match the delta base ACL and otherwise the full ACL is loaded from the URL specified by the delta ACL.
+func (cmd *LoadACL) load(u uhppote.IUHPPOTE, uri string, devices []uhppote.Device, delta bool) error {
+	a, tsv, m, signer, err := cmd.retrieve(uri)
+	if err != nil {
+		return err
+	}
+
+	if a.delta && !delta {
+		return fmt.Errorf("ACL from %v is a delta ACL (expected full ACL)", uri)
+	} else if a.delta {
+		return cmd.loadDelta(u, uri, a, tsv, m, signer, devices)
 	}
 
-	list, warnings, err := acl.ParseTSV(bytes.NewReader(tsv), devices, cmd.strict)
+	format, err := a.format()
+	if err != nil {
+		return err
+	}
+
+	g, err := a.groupDefinitions()
+	if err != nil {
+		return err
+	}
+
+	list, warnings, err := parseACL(tsv, format, g, devices, cmd.strict)
 	if err != nil {
 		return err
 	}
@@ -238,7 +373,16 @@ func (cmd *LoadACL) execute(u uhppote.IUHPPOTE, uri string, devices []uhppote.De
 			return fmt.Errorf("%v", errors)
 		}
 
-		cmd.report(current, list)
+		var memberships members
+		if g != nil {
+			if table, err := parseTable(tsv, format); err != nil {
+				return err
+			} else if memberships, err = membership(table); err != nil {
+				return err
+			}
+		}
+
+		cmd.report(current, list, memberships, signer)
 	}
 
 	put := func(u uhppote.IUHPPOTE, list acl.ACL, dryrun bool) (map[uint32]acl.Report, []error) {
@@ -250,6 +394,230 @@ func (cmd *LoadACL) execute(u uhppote.IUHPPOTE, uri string, devices []uhppote.De
 	}
 
 	rpt, errors := put(u, list, cmd.dryrun)
+
+	cmd.summarize(rpt)
+
+	if len(errors) > 0 {
+		return fmt.Errorf("%v", errors)
+	}
+
+	if !cmd.noverify && !cmd.dryrun {
+		if err := cmd.accept(uri, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fetches, unpacks, decrypts and verifies the ACL archive at the URL, returning the archive, the
+// (decrypted) ACL file and the verified manifest and signer.
+func (cmd *LoadACL) retrieve(uri string) (*archive, []byte, *manifest, *auth.Signer, error) {
+	log.Infof("Fetching ACL from %v", uri)
+
+	b, err := cmd.fetch(uri)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	log.Infof("Fetched ACL from %v (%d bytes)", uri, len(b))
+
+	// ... ACL archive referenced by an ACL pointer?
+	p := cmd.pointer
+	if p != nil && p.url != uri {
+		p = nil
+	}
+
+	if p != nil {
+		if err := p.check(b); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	a, err := unpack(uri, b)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	tsv := a.acl
+	signature := a.signature
+
+	log.Infof("Extracted ACL from %v: %v bytes, signature: %v bytes", uri, len(tsv), len(signature))
+
+	var m *manifest
+	var signer *auth.Signer
+	if !cmd.noverify {
+		if m, signer, err = a.verify(cmd.keysdir); err != nil {
+			return nil, nil, nil, nil, err
+		}
+
+		if err := checkSigner(uri, a, signer, cmd.pins); err != nil {
+			return nil, nil, nil, nil, err
+		}
+
+		if m != nil {
+			log.Infof("Verified ACL manifest (%v)", m)
+		}
+
+		if p != nil && m != nil && m.Sequence != p.Sequence {
+			return nil, nil, nil, nil, fmt.Errorf("ACL manifest sequence number (%v) does not match ACL pointer (%v)", m.Sequence, p.Sequence)
+		}
+
+		if cmd.delta != nil && (m == nil || m.Sequence < cmd.delta.Sequence) {
+			return nil, nil, nil, nil, fmt.Errorf("full ACL from %v is older than the delta ACL (sequence:%v)", uri, cmd.delta.Sequence)
+		}
+
+		// ... ACL archive without a manifest is bound to the signed pointer sequence number and issue timestamp
+		if p != nil && m == nil {
+			m = &manifest{
+				Sequence: p.Sequence,
+				Issued:   p.Issued,
+			}
+
+			log.Infof("Using ACL pointer sequence number and issue timestamp for replay and downgrade protection (%v)", p)
+		}
+
+		if err := cmd.checkDowngrade(uri, m); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	if a.encrypted {
+		if tsv, err = decrypt(a.acl, cmd.sitekey, cmd.sitePassphrase); err != nil {
+			return nil, nil, nil, nil, err
+		}
+
+		log.Infof("Decrypted ACL (%v bytes)", len(tsv))
+	}
+
+	return a, tsv, m, signer, nil
+}
+
+// Verifies and parses the ACL archive without loading it.
+func (cmd *LoadACL) check(uri string, devices []uhppote.Device) error {
+	a, body, _, _, err := cmd.retrieve(uri)
+	if err != nil {
+		return err
+	}
+
+	if a.delta {
+		_, err := parseDelta(body)
+		return err
+	}
+
+	format, err := a.format()
+	if err != nil {
+		return err
+	}
+
+	g, err := a.groupDefinitions()
+	if err != nil {
+		return err
+	}
+
+	_, _, err = parseACL(body, format, g, devices, cmd.strict)
+
+	return err
+}
+
+func (cmd *LoadACL) loadDelta(u uhppote.IUHPPOTE, uri string, a *archive, b []byte, m *manifest, signer *auth.Signer, devices []uhppote.Device) error {
+	d, err := parseDelta(b)
+	if err != nil {
+		return err
+	}
+
+	g, err := a.groupDefinitions()
+	if err != nil {
+		return err
+	}
+
+	current, errors := acl.GetACL(u, devices)
+	if len(errors) > 0 {
+		return fmt.Errorf("%v", errors)
+	}
+
+	digest, err := aclDigest(current, devices)
+	if err != nil {
+		return err
+	}
+
+	if digest != d.Base {
+		if strings.TrimSpace(d.Full) == "" {
+			return fmt.Errorf("controller ACL (%v) does not match delta ACL base (%v) and delta ACL does not specify a full ACL", digest, d.Base)
+		}
+
+		full, err := url.Parse(d.Full)
+		if err != nil {
+			return fmt.Errorf("invalid full ACL file URL '%s' (%w)", d.Full, err)
+		}
+
+		log.Warnf("Controller ACL (%v) does not match delta ACL base (%v) - loading full ACL from %v", digest, d.Base, full)
+
+		// ... replay and downgrade protection for the full ACL is kept for the delta ACL source and the full
+		//     ACL must not be older than the delta ACL
+		source, delta := cmd.source, cmd.delta
+		cmd.source, cmd.delta = cmd.key(uri), m
+
+		defer func() {
+			cmd.source, cmd.delta = source, delta
+		}()
+
+		return cmd.load(u, full.String(), devices, false)
+	}
+
+	log.Infof("Controller ACL matches delta ACL base (%v)", d.Base)
+
+	list, changes, err := d.apply(current, g, devices)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range changes {
+		log.Infof("%v  Retrieved delta  added:%v  updated:%v  revoked:%v", k, len(v.Added), len(v.Updated), len(v.Deleted))
+	}
+
+	if !cmd.noreport {
+		if g != nil {
+			if memberships, err := membership(cardsTable(slices.Concat(d.Add, d.Update))); err != nil {
+				return err
+			} else {
+				cmd.report(current, list, memberships, signer)
+			}
+		} else {
+			cmd.report(current, list, nil, signer)
+		}
+	}
+
+	rpt := putDelta(u, current, changes, cmd.withPIN, cmd.dryrun)
+
+	cmd.summarize(rpt)
+
+	errors = []error{}
+	for _, id := range slices.Sorted(maps.Keys(rpt)) {
+		r := rpt[id]
+		if len(r.Errors) > 0 {
+			errors = append(errors, fmt.Errorf("%v  %v", id, r.Errors))
+		}
+
+		if len(r.Failed) > 0 {
+			errors = append(errors, fmt.Errorf("%v  failed to update cards %v", id, r.Failed))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("%v", errors)
+	}
+
+	if !cmd.noverify && !cmd.dryrun {
+		if err := cmd.accept(uri, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cmd *LoadACL) summarize(rpt map[uint32]acl.Report) {
 	for k, v := range rpt {
 		log.Infof("%v  SUMMARY  unchanged:%v  updated:%v  added:%v  deleted:%v  failed:%v  errors:%v",
 			k,
@@ -260,14 +628,83 @@ func (cmd *LoadACL) execute(u uhppote.IUHPPOTE, uri string, devices []uhppote.De
 			len(v.Failed),
 			len(v.Errors))
 	}
+}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("%v", errors)
+func (cmd *LoadACL) checkDowngrade(uri string, m *manifest) error {
+	s, err := loadState(filepath.Join(cmd.workdir, STATE_FILE))
+	if err != nil {
+		return err
+	}
+
+	if !s.isDowngrade(cmd.key(uri), m) {
+		if m == nil {
+			log.Warnf("ACL from %v does not include a manifest - replay and downgrade protection is not available", uri)
+		}
+
+		return nil
+	}
+
+	last := s[cmd.key(uri)]
+	if m == nil {
+		log.Warnf("SECURITY  ACL from %v does not include a manifest (last accepted ACL sequence:%v issued:%v)",
+			uri, last.Sequence, last.Issued.Format(time.RFC3339))
+	} else {
+		log.Warnf("SECURITY  ACL from %v (%v) is older than the last accepted ACL (sequence:%v issued:%v)",
+			uri, m, last.Sequence, last.Issued.Format(time.RFC3339))
+	}
+
+	if !cmd.allowDowngrade {
+		return fmt.Errorf("rejected possible replay of older ACL from %v (use --allow-downgrade to override)", uri)
 	}
 
+	log.Warnf("SECURITY  loading older ACL from %v (--allow-downgrade)", uri)
+
 	return nil
 }
 
+// Updates the replay and downgrade protection state for an accepted ACL. ACLs without a manifest
+// (only accepted with --allow-downgrade once an ACL with a manifest has been accepted) and ACLs that
+// are older than the last accepted ACL leave the state unchanged.
+func (cmd *LoadACL) accept(uri string, m *manifest) error {
+	if m == nil {
+		return nil
+	}
+
+	file := filepath.Join(cmd.workdir, STATE_FILE)
+
+	s, err := loadState(file)
+	if err != nil {
+		return err
+	}
+
+	if !s.accept(cmd.key(uri), m) {
+		return nil
+	}
+
+	return s.save(file)
+}
+
+// Returns the state file key for the replay and downgrade protection, which is the ACL URL unless the
+// ACLs are loaded from a drop directory, URL prefix or ACL pointer.
+func (cmd *LoadACL) key(uri string) string {
+	if cmd.source != "" {
+		return cmd.source
+	}
+
+	return uri
+}
+
+func (cmd *LoadACL) fetch(uri string) ([]byte, error) {
+	f := cmd.fetchHTTP
+	if strings.HasPrefix(uri, "s3://") {
+		f = cmd.fetchS3
+	} else if strings.HasPrefix(uri, "file://") {
+		f = cmd.fetchFile
+	}
+
+	return f(uri)
+}
+
 func (cmd *LoadACL) fetchHTTP(url string) ([]byte, error) {
 	return fetchHTTP(url)
 }
@@ -280,7 +717,7 @@ func (cmd *LoadACL) fetchFile(url string) ([]byte, error) {
 	return fetchFile(url)
 }
 
-func (cmd *LoadACL) report(current, list acl.ACL) error {
+func (cmd *LoadACL) report(current, list acl.ACL, m members, signer *auth.Signer) error {
 	log.Infof("Generating ACL 'diff' report")
 
 	diff, err := acl.Compare(current, list)
@@ -288,7 +725,12 @@ func (cmd *LoadACL) report(current, list acl.ACL) error {
 		return err
 	}
 
-	report(diff, cmd.template, os.Stdout)
+	var groups map[string]GroupDiff
+	if m != nil {
+		groups = compareGroups(diff, m)
+	}
+
+	report(diff, groups, signer, cmd.template, os.Stdout)
 
 	filename := time.Now().Format("acl-2006-01-02T150405.rpt")
 	file := filepath.Join(cmd.workdir, filename)
@@ -301,5 +743,5 @@ func (cmd *LoadACL) report(current, list acl.ACL) error {
 
 	log.Infof("Writing 'diff' report to %v", f.Name())
 
-	return report(diff, cmd.template, f)
+	return report(diff, groups, signer, cmd.template, f)
 }
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/uhppoted/uhppoted-app-s3/auth"
+)
+
+// Optional signed archive manifest. If an archive includes a manifest, the 'signature' file is the
+// signature of the manifest and the manifest binds the ACL (and any other files) to the signature
+// by their SHA-256 digests. The (optional) format overrides the ACL file format implied by the
+// ACL file extension.
+type manifest struct {
+	Sequence  uint64            `json:"sequence"`
+	Issued    time.Time         `json:"issued"`
+	Format    string            `json:"format,omitempty"`
+	Delimiter string            `json:"delimiter,omitempty"`
+	Sheet     string            `json:"sheet,omitempty"`
+	Files     map[string]string `json:"files"`
+}
+
+func parseManifest(b []byte) (*manifest, error) {
+	m := manifest{}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest (%w)", err)
+	}
+
+	return &m, nil
+}
+
+func (m manifest) String() string {
+	return fmt.Sprintf("sequence:%v issued:%v", m.Sequence, m.Issued.Format(time.RFC3339))
+}
+
+// Verifies the archive signature. For archives without a manifest the signature is verified
+// against the ACL file as stored (i.e. the encrypted ACL file for ACLs with card PIN codes),
+// otherwise against the manifest, along with the digests of the files listed in the manifest
+// (which must include the 'groups' file, if any). Returns the manifest (if any) and the verified
+// signer.
+func (a *archive) verify(keysdir string) (*manifest, *auth.Signer, error) {
+	if a.manifest == nil && a.groups != nil {
+		return nil, nil, fmt.Errorf("archive 'groups' file requires a signed manifest")
+	} else if a.manifest == nil {
+		signer, err := verify(a.uname, a.acl, a.signature, keysdir)
+
+		return nil, signer, err
+	}
+
+	signer, err := verify(a.uname, a.manifest, a.signature, keysdir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := parseManifest(a.manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, ok := m.Files[a.name]; !ok {
+		return nil, nil, fmt.Errorf("ACL file '%v' is not listed in manifest", a.name)
+	}
+
+	if _, ok := m.Files["groups"]; a.groups != nil && !ok {
+		return nil, nil, fmt.Errorf("'groups' file is not listed in manifest")
+	}
+
+	for name, digest := range m.Files {
+		body, ok := a.entries[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("manifest file '%v' missing from archive", name)
+		}
+
+		hash := sha256.Sum256(body)
+		if hex.EncodeToString(hash[:]) != digest {
+			return nil, nil, fmt.Errorf("SHA-256 digest of '%v' does not match manifest", name)
+		}
+	}
+
+	return m, signer, nil
+}
+
+func makeManifest(sequence uint64, issued time.Time, format fileFormat, files map[string][]byte) ([]byte, error) {
+	m := manifest{
+		Sequence:  sequence,
+		Issued:    issued.UTC().Truncate(time.Second),
+		Format:    format.name,
+		Delimiter: format.delimiter,
+		Sheet:     format.sheet,
+		Files:     map[string]string{},
+	}
+
+	for name, body := range files {
+		hash := sha256.Sum256(body)
+		m.Files[name] = hex.EncodeToString(hash[:])
+	}
+
+	return json.MarshalIndent(m, "", "  ")
+}
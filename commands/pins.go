@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/uhppoted/uhppoted-lib/config"
+	"github.com/uhppoted/uhppoted-lib/encoding/conf"
+
+	"github.com/uhppoted/uhppoted-app-s3/auth"
+)
+
+// Key fingerprints authorised to sign the ACL files for a source URL (or URL prefix), configured in
+// uhppoted.conf as e.g.
+//
+//	s3.acl.keys.s3://uhppoted/hogwarts/ = SHA256:AgwKbxas4HL3...,SHA256:u8GLHXPEdeDq...
+//
+// ACL files from a URL that does not match any configured prefix may be signed by any key in the
+// keys directory.
+type pins map[string][]string
+
+type pinsConf struct {
+	Pins pins `conf:"/^s3\\.acl\\.keys\\.(.+)$/"`
+}
+
+// Loads the configuration and the pinned ACL signing keys from a single read of the configuration file.
+// The pins are returned (empty) along with the error if the configuration file does not exist.
+func loadConfig(file string, c *config.Config) (pins, error) {
+	p := pinsConf{
+		Pins: pins{},
+	}
+
+	if file == "" {
+		return p.Pins, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return p.Pins, err
+	}
+
+	if err := c.Read(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+
+	return p.Pins, nil
+}
+
+func (p *pins) UnmarshalConf(tag string, values map[string]string) (any, error) {
+	re := regexp.MustCompile(`^/(.*?)/$`)
+	match := re.FindStringSubmatch(tag)
+	if len(match) < 2 {
+		return p, fmt.Errorf("invalid 'conf' regular expression tag: %s", tag)
+	}
+
+	re, err := regexp.Compile(match[1])
+	if err != nil {
+		return p, err
+	}
+
+	for key, value := range values {
+		if match := re.FindStringSubmatch(key); len(match) > 1 {
+			fingerprints := []string{}
+			for _, v := range strings.Split(value, ",") {
+				if fp := strings.TrimSpace(v); fp != "" {
+					fingerprints = append(fingerprints, fp)
+				}
+			}
+
+			if len(fingerprints) == 0 {
+				return p, fmt.Errorf("no key fingerprints for ACL source %v", match[1])
+			}
+
+			(*p)[match[1]] = fingerprints
+		}
+	}
+
+	return p, nil
+}
+
+// Returns an error if the ACL source URL matches a configured prefix and the signing key is not one
+// of the keys pinned for the (longest) matching prefix. A prefix matches on a path boundary i.e. the
+// URL is the prefix or is 'below' the prefix (s3://uhppoted/hogwarts matches s3://uhppoted/hogwarts/acl.tar.gz
+// but not s3://uhppoted/hogwarts-old/acl.tar.gz).
+func (p pins) check(uri string, signer *auth.Signer) error {
+	prefix := ""
+	for k := range p {
+		if (uri == k || strings.HasPrefix(uri, strings.TrimSuffix(k, "/")+"/")) && len(k) > len(prefix) {
+			prefix = k
+		}
+	}
+
+	if prefix == "" {
+		return nil
+	}
+
+	for _, fp := range p[prefix] {
+		if fp == signer.KeyID {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("signing key %v is not authorised for ACL files from %v", signer, prefix)
+}
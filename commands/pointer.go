@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/uhppoted/uhppoted-app-s3/auth"
+)
+
+// Signed pointer (e.g. latest.json) to the current ACL archive, for publishing to stores without atomic
+// overwrite semantics: the ACL archive is uploaded as a new object and the pointer is then updated to
+// reference it. The pointer binds the ACL archive by SHA-256 digest and manifest sequence number and the
+// ACL URL may be relative to the pointer URL. The signature is a JSON signature envelope for the JSON
+// encoded pointer fields.
+type pointer struct {
+	ACL      string    `json:"acl"`
+	SHA256   string    `json:"sha256"`
+	Sequence uint64    `json:"sequence"`
+	Issued   time.Time `json:"issued"`
+	url      string
+}
+
+type signedPointer struct {
+	pointer
+	Signature json.RawMessage `json:"signature,omitempty"`
+}
+
+// Returns true if the URL is for an ACL pointer rather than an ACL archive.
+func isPointer(uri string) bool {
+	if u, err := url.Parse(uri); err == nil {
+		return strings.HasSuffix(u.Path, ".json")
+	}
+
+	return false
+}
+
+// Parses the ACL pointer, returning the pointer, the signed content and the signature.
+func parsePointer(b []byte) (*pointer, []byte, []byte, error) {
+	p := signedPointer{}
+
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid ACL pointer (%w)", err)
+	}
+
+	if strings.TrimSpace(p.ACL) == "" {
+		return nil, nil, nil, fmt.Errorf("invalid ACL pointer (missing ACL URL)")
+	}
+
+	if strings.TrimSpace(p.SHA256) == "" {
+		return nil, nil, nil, fmt.Errorf("invalid ACL pointer (missing SHA-256 digest)")
+	}
+
+	signed, err := json.Marshal(p.pointer)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &p.pointer, signed, p.Signature, nil
+}
+
+// Resolves the ACL URL against the pointer URL.
+func (p *pointer) resolve(base string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := url.Parse(p.ACL)
+	if err != nil {
+		return "", fmt.Errorf("invalid ACL pointer URL '%v' (%w)", p.ACL, err)
+	}
+
+	p.url = b.ResolveReference(r).String()
+
+	return p.url, nil
+}
+
+// Verifies the SHA-256 digest of the ACL archive referenced by the pointer.
+func (p pointer) check(archive []byte) error {
+	hash := sha256.Sum256(archive)
+	if digest := hex.EncodeToString(hash[:]); !strings.EqualFold(digest, p.SHA256) {
+		return fmt.Errorf("SHA-256 digest of %v (%v) does not match ACL pointer (%v)", p.url, digest, p.SHA256)
+	}
+
+	return nil
+}
+
+func (p pointer) String() string {
+	return fmt.Sprintf("sequence:%v issued:%v", p.Sequence, p.Issued.Format(time.RFC3339))
+}
+
+// Creates a signed ACL pointer to the ACL archive. The ACL URL is relative to the pointer URL if the
+// pointer and ACL archive are in the same 'directory'.
+func makePointer(uri string, acl string, archive []byte, m *manifest, keyfile string, passphrase string) ([]byte, error) {
+	ref := acl
+	if u, err := url.Parse(uri); err == nil {
+		if v, err := url.Parse(acl); err == nil && u.Scheme == v.Scheme && u.Host == v.Host && path.Dir(u.Path) == path.Dir(v.Path) {
+			ref = path.Base(v.Path)
+		}
+	}
+
+	hash := sha256.Sum256(archive)
+	p := pointer{
+		ACL:      ref,
+		SHA256:   hex.EncodeToString(hash[:]),
+		Sequence: m.Sequence,
+		Issued:   m.Issued.UTC().Truncate(time.Second),
+	}
+
+	signed, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := auth.Seal(signed, keyfile, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(signedPointer{pointer: p, Signature: signature}, "", "  ")
+}
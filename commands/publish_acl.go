@@ -0,0 +1,486 @@
+package commands
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	syslog "log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/uhppoted/uhppote-core/uhppote"
+	"github.com/uhppoted/uhppoted-lib/acl"
+	"github.com/uhppoted/uhppoted-lib/config"
+
+	"github.com/uhppoted/uhppoted-app-s3/log"
+)
+
+var PublishACLCmd = PublishACL{
+	config:      config.DefaultConfig,
+	keysdir:     DEFAULT_KEYSDIR,
+	signature:   signatureJWS,
+	credentials: DEFAULT_CREDENTIALS,
+	profile:     DEFAULT_PROFILE,
+	region:      DEFAULT_REGION,
+	sequence:    0,
+	maxChanges:  "",
+	strict:      false,
+	dryrun:      false,
+	debug:       false,
+}
+
+type PublishACL struct {
+	acl         string
+	url         string
+	pointer     string
+	config      string
+	uname       string
+	keyfile     string
+	passphrase  string
+	signature   string
+	keysdir     string
+	credentials string
+	profile     string
+	region      string
+	sequence    uint64
+	delimiter   string
+	sheet       string
+	groups      string
+	maxChanges  string
+	pins        pins
+	strict      bool
+	dryrun      bool
+	debug       bool
+}
+
+func (cmd *PublishACL) Name() string {
+	return "publish-acl"
+}
+
+func (cmd *PublishACL) FlagSet() *flag.FlagSet {
+	flagset := flag.NewFlagSet("publish-acl", flag.ExitOnError)
+
+	flagset.StringVar(&cmd.acl, "acl", cmd.acl, "ACL file to publish")
+	flagset.StringVar(&cmd.url, "url", cmd.url, "URL to which to upload the signed ACL archive")
+	flagset.StringVar(&cmd.pointer, "pointer", cmd.pointer, "URL of a signed ACL pointer (e.g. latest.json) to update to reference the uploaded ACL archive")
+	flagset.StringVar(&cmd.uname, "uname", cmd.uname, "User ID of the signing key")
+	flagset.StringVar(&cmd.keyfile, "key", cmd.keyfile, "RSA signing key file (or PKCS#11 URI for a key held in a PKCS#11 token)")
+	flagset.StringVar(&cmd.passphrase, "passphrase", cmd.passphrase, "Passphrase for an encrypted RSA signing key (or PKCS#11 token PIN), specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.StringVar(&cmd.signature, "signature", cmd.signature, "Signature format, either 'rsa' (raw RSA signature) or 'jws' (JSON signature envelope). Defaults to 'jws'")
+	flagset.Uint64Var(&cmd.sequence, "sequence", cmd.sequence, "ACL sequence number for the manifest (defaults to the current UNIX time)")
+	flagset.StringVar(&cmd.delimiter, "delimiter", cmd.delimiter, "CSV ACL file delimiter (defaults to ',')")
+	flagset.StringVar(&cmd.sheet, "sheet", cmd.sheet, "XLSX ACL file worksheet (defaults to the first worksheet)")
+	flagset.StringVar(&cmd.groups, "groups", cmd.groups, "Group definitions file for an ACL file that assigns cards to groups")
+	flagset.StringVar(&cmd.maxChanges, "max-changes", cmd.maxChanges, "Refuses to publish the ACL if the number of changed cards (or percentage e.g. 10%) exceeds the threshold")
+	flagset.StringVar(&cmd.keysdir, "keys", cmd.keysdir, "Sets the directory to search for the RSA signing keys of the currently published ACL for --max-changes")
+	flagset.StringVar(&cmd.credentials, "credentials", cmd.credentials, "AWS credentials file")
+	flagset.StringVar(&cmd.profile, "profile", cmd.profile, "AWS credentials file profile (defaults to 'default')")
+	flagset.StringVar(&cmd.region, "region", cmd.region, "AWS region for S3 (defaults to us-east-1)")
+	flagset.BoolVar(&cmd.strict, "strict", cmd.strict, "Fails if the ACL contains duplicate card numbers")
+	flagset.BoolVar(&cmd.dryrun, "dry-run", cmd.dryrun, "Validates and signs the ACL without uploading it")
+
+	return flagset
+}
+
+func (cmd *PublishACL) Description() string {
+	return "Validates, signs and uploads an ACL file to S3"
+}
+
+func (cmd *PublishACL) Usage() string {
+	return "publish-acl --acl <file> --uname <user ID> --key <file> --url <URL>"
+}
+
+func (cmd *PublishACL) Help() {
+	fmt.Println()
+	fmt.Printf("  Usage: %s [--debug] [--config <file>] publish-acl --acl <file> --uname <user ID> --key <file> --url <URL> [--pointer <URL>] [--passphrase <source>] [--signature rsa|jws] [--sequence <number>] [--delimiter <char>] [--sheet <name>] [--groups <file>] [--max-changes <N|N%%>] [--keys <dir>] [--credentials <file>] [--profile <file>] [--region <region>] [--strict] [--dry-run]\n", APP)
+	fmt.Println()
+	fmt.Println("    Validates the ACL file against the devices and doors in the configuration file, signs it and uploads the")
+	fmt.Println("    signed archive to the URL. The --max-changes option compares the ACL with the (verified) ACL currently")
+	fmt.Println("    published at the URL and refuses to publish the ACL if the number of changed cards exceeds the threshold.")
+	fmt.Println("    The first ACL published to a URL (i.e. nothing currently published) is not subject to the threshold.")
+	fmt.Println()
+	fmt.Println("    The --pointer option updates a signed ACL pointer (e.g. latest.json) to reference the uploaded ACL archive")
+	fmt.Println("    once it has been uploaded, for atomic publishing to stores that do not support atomic overwrites. With")
+	fmt.Println("    --pointer, the --max-changes option compares the ACL with the ACL referenced by the currently published")
+	fmt.Println("    (verified) pointer.")
+	fmt.Println()
+
+	helpOptions(cmd.FlagSet())
+	fmt.Println()
+}
+
+func (cmd *PublishACL) Execute(args ...interface{}) error {
+	options := args[0].(*Options)
+
+	cmd.config = options.Config
+	cmd.debug = options.Debug
+
+	// ... check parameters
+	if strings.TrimSpace(cmd.acl) == "" {
+		return fmt.Errorf("publish-acl requires an ACL file")
+	}
+
+	if strings.TrimSpace(cmd.url) == "" {
+		return fmt.Errorf("publish-acl requires a URL to which to upload the signed ACL")
+	}
+
+	if strings.TrimSpace(cmd.uname) == "" {
+		return fmt.Errorf("publish-acl requires the user ID of the signing key")
+	}
+
+	if strings.TrimSpace(cmd.keyfile) == "" {
+		return fmt.Errorf("publish-acl requires an RSA signing key")
+	}
+
+	uri, err := url.Parse(cmd.url)
+	if err != nil {
+		return fmt.Errorf("invalid upload URL '%s' (%w)", cmd.url, err)
+	}
+
+	if cmd.pointer != "" {
+		if _, err := url.Parse(cmd.pointer); err != nil {
+			return fmt.Errorf("invalid ACL pointer URL '%s' (%w)", cmd.pointer, err)
+		} else if !isPointer(cmd.pointer) {
+			return fmt.Errorf("invalid ACL pointer URL '%s' (expected .json file)", cmd.pointer)
+		}
+	}
+
+	if err := checkSignatureFormat(cmd.signature); err != nil {
+		return err
+	}
+
+	conf := config.NewConfig()
+	if cmd.pins, err = loadConfig(cmd.config, conf); err != nil {
+		return fmt.Errorf("WARN  Could not load configuration (%v)", err)
+	}
+
+	if cmd.credentials == "" {
+		cmd.credentials = conf.AWS.Credentials
+	}
+
+	if cmd.profile == "" {
+		cmd.profile = conf.AWS.Profile
+	}
+
+	if cmd.region == "" {
+		cmd.region = conf.AWS.Region
+	}
+
+	log.SetLogger(syslog.New(os.Stdout, "ACL ", syslog.LstdFlags|syslog.LUTC|syslog.Lmsgprefix))
+
+	return cmd.execute(uri.String(), conf.Devices.ToControllers())
+}
+
+func (cmd *PublishACL) execute(uri string, devices []uhppote.Device) error {
+	b, err := os.ReadFile(cmd.acl)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Base(cmd.acl)
+	if !isACL(filename) {
+		return fmt.Errorf("invalid ACL file name '%v' (expected .acl, .csv, .json or .xlsx file)", filename)
+	}
+
+	format := fileFormat{
+		name:      aclFormat(filename),
+		delimiter: cmd.delimiter,
+		sheet:     cmd.sheet,
+	}
+
+	var gb []byte
+	var g groups
+	if cmd.groups != "" {
+		if gb, err = os.ReadFile(cmd.groups); err != nil {
+			return err
+		} else if g, err = parseGroups(gb); err != nil {
+			return err
+		}
+	}
+
+	list, warnings, err := parseACL(b, format, g, devices, cmd.strict)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range warnings {
+		log.Warnf("%v", w)
+	}
+
+	for k, l := range list {
+		log.Infof("%v  Validated %v records", k, len(l))
+	}
+
+	signer := SignACL{
+		uname:      cmd.uname,
+		keyfile:    cmd.keyfile,
+		passphrase: cmd.passphrase,
+		signature:  cmd.signature,
+		sequence:   cmd.sequence,
+		delimiter:  cmd.delimiter,
+		sheet:      cmd.sheet,
+	}
+
+	files, err := signer.sign(filename, b, gb)
+	if err != nil {
+		return err
+	}
+
+	m, err := parseManifest(files["manifest"])
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Signed %v as %v (%v)", filename, cmd.uname, m)
+
+	if cmd.maxChanges != "" {
+		if err := cmd.checkChanges(uri, list, m, devices); err != nil {
+			return err
+		}
+	}
+
+	var archive bytes.Buffer
+	x := targz
+	if strings.HasSuffix(uri, ".zip") {
+		x = zipf
+	}
+
+	if err := x(files, cmd.uname, &archive); err != nil {
+		return err
+	}
+
+	var p []byte
+	if cmd.pointer != "" {
+		if p, err = makePointer(cmd.pointer, uri, archive.Bytes(), m, cmd.keyfile, cmd.passphrase); err != nil {
+			return err
+		}
+	}
+
+	if cmd.dryrun {
+		log.Infof("Dry run - not publishing signed ACL (%v bytes) to %v", archive.Len(), uri)
+		return nil
+	}
+
+	if err := cmd.store(uri, archive.Bytes()); err != nil {
+		return err
+	}
+
+	log.Infof("Published signed ACL (%v bytes) to %v", archive.Len(), uri)
+
+	// ... update pointer only after the ACL archive has been uploaded
+	if p != nil {
+		if err := cmd.store(cmd.pointer, p); err != nil {
+			return err
+		}
+
+		log.Infof("Updated ACL pointer %v to reference %v", cmd.pointer, uri)
+	}
+
+	return nil
+}
+
+func (cmd *PublishACL) store(uri string, b []byte) error {
+	f := cmd.storeHTTP
+	if strings.HasPrefix(uri, "s3://") {
+		f = cmd.storeS3
+	} else if strings.HasPrefix(uri, "file://") {
+		f = cmd.storeFile
+	}
+
+	return f(uri, bytes.NewReader(b))
+}
+
+func (cmd *PublishACL) fetch(uri string) ([]byte, error) {
+	f := cmd.fetchHTTP
+	if strings.HasPrefix(uri, "s3://") {
+		f = cmd.fetchS3
+	} else if strings.HasPrefix(uri, "file://") {
+		f = cmd.fetchFile
+	}
+
+	return f(uri)
+}
+
+// Compares the ACL with the ACL currently published at the URL (or referenced by the ACL pointer) and
+// returns an error if the number of changed cards exceeds the threshold or if the published ACL has a
+// later sequence number.
+func (cmd *PublishACL) checkChanges(uri string, list acl.ACL, m *manifest, devices []uhppote.Device) error {
+	var p *pointer
+	if cmd.pointer != "" {
+		var err error
+		if p, err = cmd.currentPointer(); err != nil {
+			return err
+		} else if p == nil {
+			log.Infof("No ACL pointer currently published at %v - first publish is not subject to --max-changes", cmd.pointer)
+			return nil
+		} else if p.Sequence >= m.Sequence {
+			return fmt.Errorf("published ACL pointer sequence number (%v) is not earlier than the ACL sequence number (%v)", p.Sequence, m.Sequence)
+		}
+
+		uri = p.url
+	}
+
+	current, err := cmd.published(uri, p, m, devices)
+	if err != nil {
+		return err
+	} else if current == nil {
+		log.Infof("No ACL currently published at %v - first publish is not subject to --max-changes", uri)
+		return nil
+	}
+
+	diff, err := acl.Compare(current, list)
+	if err != nil {
+		return err
+	}
+
+	system := acl.SystemDiff(diff)
+	consolidated := system.Consolidate()
+	changes := len(consolidated.Updated) + len(consolidated.Added) + len(consolidated.Deleted)
+	total := len(consolidated.Unchanged) + len(consolidated.Updated) + len(consolidated.Deleted)
+
+	log.Infof("Changes from published ACL  updated:%v  added:%v  deleted:%v",
+		len(consolidated.Updated),
+		len(consolidated.Added),
+		len(consolidated.Deleted))
+
+	if threshold, ok := strings.CutSuffix(cmd.maxChanges, "%"); ok {
+		percentage, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			return fmt.Errorf("invalid --max-changes threshold '%v'", cmd.maxChanges)
+		}
+
+		if total > 0 && 100.0*float64(changes)/float64(total) > percentage {
+			return fmt.Errorf("%v changed cards exceeds the --max-changes threshold (%v of %v cards)", changes, cmd.maxChanges, total)
+		} else if total == 0 && changes > 0 && percentage < 100.0 {
+			return fmt.Errorf("%v changed cards exceeds the --max-changes threshold (%v of %v cards)", changes, cmd.maxChanges, total)
+		}
+	} else {
+		N, err := strconv.Atoi(threshold)
+		if err != nil {
+			return fmt.Errorf("invalid --max-changes threshold '%v'", cmd.maxChanges)
+		}
+
+		if changes > N {
+			return fmt.Errorf("%v changed cards exceeds the --max-changes threshold (%v)", changes, N)
+		}
+	}
+
+	return nil
+}
+
+// Fetches and verifies the ACL pointer currently published at the --pointer URL, returning the pointer
+// with the resolved URL of the currently published ACL. Returns nil if no pointer has been published.
+func (cmd *PublishACL) currentPointer() (*pointer, error) {
+	b, err := cmd.fetch(cmd.pointer)
+	if err != nil && isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving currently published ACL pointer (%w)", err)
+	}
+
+	p, signed, signature, err := parsePointer(b)
+	if err != nil {
+		return nil, err
+	} else if len(signature) == 0 {
+		return nil, fmt.Errorf("ACL pointer %v is not signed", cmd.pointer)
+	}
+
+	signer, err := verify("", signed, signature, cmd.keysdir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACL pointer signature (%w)", err)
+	} else if err := cmd.pins.check(cmd.pointer, signer); err != nil {
+		return nil, err
+	}
+
+	acl, err := p.resolve(cmd.pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Verified currently published ACL pointer %v signed by %v (references %v)", cmd.pointer, signer, acl)
+
+	return p, nil
+}
+
+// Fetches, verifies and parses the ACL currently published at the URL. The published ACL must be signed
+// by a trusted (and pinned) key so that a tampered ACL cannot be used to circumvent the --max-changes
+// threshold and must match the ACL pointer (if any). Returns nil if nothing has been published at the URL.
+func (cmd *PublishACL) published(uri string, p *pointer, m *manifest, devices []uhppote.Device) (acl.ACL, error) {
+	b, err := cmd.fetch(uri)
+	if err != nil && isNotFound(err) && p == nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving currently published ACL (%w)", err)
+	}
+
+	if p != nil {
+		if err := p.check(b); err != nil {
+			return nil, err
+		}
+	}
+
+	a, err := unpack(uri, b)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving currently published ACL (%w)", err)
+	} else if a.encrypted {
+		return nil, fmt.Errorf("currently published ACL is encrypted")
+	}
+
+	published, signer, err := a.verify(cmd.keysdir)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying currently published ACL (%w)", err)
+	} else if err := cmd.pins.check(uri, signer); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Verified currently published ACL signed by %v", signer)
+
+	if published != nil && published.Sequence >= m.Sequence {
+		return nil, fmt.Errorf("published ACL sequence number (%v) is not earlier than the ACL sequence number (%v)", published.Sequence, m.Sequence)
+	}
+
+	format, err := a.format()
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := a.groupDefinitions()
+	if err != nil {
+		return nil, err
+	}
+
+	current, _, err := parseACL(a.acl, format, g, devices, false)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing currently published ACL (%w)", err)
+	}
+
+	return current, nil
+}
+
+func (cmd *PublishACL) fetchHTTP(url string) ([]byte, error) {
+	return fetchHTTP(url)
+}
+
+func (cmd *PublishACL) fetchS3(url string) ([]byte, error) {
+	return fetchS3(url, cmd.credentials, cmd.profile, cmd.region)
+}
+
+func (cmd *PublishACL) fetchFile(url string) ([]byte, error) {
+	return fetchFile(url)
+}
+
+func (cmd *PublishACL) storeHTTP(url string, r io.Reader) error {
+	return storeHTTP(url, r)
+}
+
+func (cmd *PublishACL) storeS3(uri string, r io.Reader) error {
+	return storeS3(uri, cmd.credentials, cmd.profile, cmd.region, r)
+}
+
+func (cmd *PublishACL) storeFile(url string, r io.Reader) error {
+	return storeFile(url, r)
+}
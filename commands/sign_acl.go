@@ -0,0 +1,210 @@
+package commands
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var SignACLCmd = SignACL{
+	passphrase: "",
+	signature:  signatureJWS,
+	sequence:   0,
+	nomanifest: false,
+}
+
+type SignACL struct {
+	acl        string
+	out        string
+	uname      string
+	keyfile    string
+	passphrase string
+	signature  string
+	sequence   uint64
+	delimiter  string
+	sheet      string
+	groups     string
+	nomanifest bool
+}
+
+func (cmd *SignACL) Name() string {
+	return "sign-acl"
+}
+
+func (cmd *SignACL) FlagSet() *flag.FlagSet {
+	flagset := flag.NewFlagSet("sign-acl", flag.ExitOnError)
+
+	flagset.StringVar(&cmd.acl, "acl", cmd.acl, "ACL file to sign")
+	flagset.StringVar(&cmd.out, "out", cmd.out, "Signed ACL archive file (.tar.gz or .zip)")
+	flagset.StringVar(&cmd.uname, "uname", cmd.uname, "User ID of the signing key")
+	flagset.StringVar(&cmd.keyfile, "key", cmd.keyfile, "RSA signing key file (or PKCS#11 URI for a key held in a PKCS#11 token)")
+	flagset.StringVar(&cmd.passphrase, "passphrase", cmd.passphrase, "Passphrase for an encrypted RSA signing key (or PKCS#11 token PIN), specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.StringVar(&cmd.signature, "signature", cmd.signature, "Signature format, either 'rsa' (raw RSA signature) or 'jws' (JSON signature envelope). Defaults to 'jws'")
+	flagset.Uint64Var(&cmd.sequence, "sequence", cmd.sequence, "ACL sequence number for the manifest (defaults to the current UNIX time)")
+	flagset.StringVar(&cmd.delimiter, "delimiter", cmd.delimiter, "CSV ACL file delimiter for the manifest (defaults to ',')")
+	flagset.StringVar(&cmd.sheet, "sheet", cmd.sheet, "XLSX ACL file worksheet for the manifest (defaults to the first worksheet)")
+	flagset.StringVar(&cmd.groups, "groups", cmd.groups, "Group definitions file for an ACL file that assigns cards to groups (requires a manifest)")
+	flagset.BoolVar(&cmd.nomanifest, "no-manifest", cmd.nomanifest, "Signs the ACL file directly rather than including a signed manifest in the archive")
+
+	return flagset
+}
+
+func (cmd *SignACL) Description() string {
+	return "Signs an ACL file and packages it as a .tar.gz or .zip archive"
+}
+
+func (cmd *SignACL) Usage() string {
+	return "sign-acl --acl <file> --uname <user ID> --key <file> --out <file>"
+}
+
+func (cmd *SignACL) Help() {
+	fmt.Println()
+	fmt.Printf("  Usage: %s sign-acl --acl <file> --uname <user ID> --key <file> [--passphrase <source>] [--signature rsa|jws] [--sequence <number>] [--delimiter <char>] [--sheet <name>] [--groups <file>] [--no-manifest] --out <file>\n", APP)
+	fmt.Println()
+	fmt.Println("    Signs an ACL (or delta ACL) file with the RSA key for the user ID and packages the ACL file, signed manifest and signature")
+	fmt.Println("    as a .tar.gz (or .zip) archive for load-acl and compare-acl.")
+	fmt.Println()
+
+	helpOptions(cmd.FlagSet())
+	fmt.Println()
+}
+
+func (cmd *SignACL) Execute(args ...interface{}) error {
+	if strings.TrimSpace(cmd.acl) == "" {
+		return fmt.Errorf("sign-acl requires an ACL file")
+	}
+
+	if strings.TrimSpace(cmd.out) == "" {
+		return fmt.Errorf("sign-acl requires an output file")
+	}
+
+	if strings.TrimSpace(cmd.uname) == "" {
+		return fmt.Errorf("sign-acl requires the user ID of the signing key")
+	}
+
+	if strings.TrimSpace(cmd.keyfile) == "" {
+		return fmt.Errorf("sign-acl requires an RSA signing key")
+	}
+
+	if err := checkSignatureFormat(cmd.signature); err != nil {
+		return err
+	}
+
+	acl, err := os.ReadFile(cmd.acl)
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Base(cmd.acl)
+	if !isACL(filename) && !isDelta(filename) {
+		return fmt.Errorf("invalid ACL file name '%v' (expected .acl, .csv, .json, .xlsx or .delta file)", filename)
+	}
+
+	var groups []byte
+	if cmd.groups != "" {
+		if groups, err = os.ReadFile(cmd.groups); err != nil {
+			return err
+		} else if _, err := parseGroups(groups); err != nil {
+			return err
+		}
+	}
+
+	files, err := cmd.sign(filename, acl, groups)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	x := targz
+	if strings.HasSuffix(cmd.out, ".zip") {
+		x = zipf
+	}
+
+	if err := x(files, cmd.uname, &b); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(cmd.out, b.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("  Signed %v (%v bytes) as %v: %v bytes\n", filename, len(acl), cmd.uname, b.Len())
+	if m, ok := files["manifest"]; ok {
+		if v, err := parseManifest(m); err == nil {
+			fmt.Printf("  Manifest %v\n", v)
+		}
+	}
+	fmt.Printf("  Created %v\n", cmd.out)
+	fmt.Println()
+
+	return nil
+}
+
+func (cmd *SignACL) sign(filename string, acl []byte, groups []byte) (map[string][]byte, error) {
+	files := map[string][]byte{
+		filename: acl,
+	}
+
+	if groups != nil && cmd.nomanifest {
+		return nil, fmt.Errorf("ACL group definitions require a signed manifest")
+	} else if cmd.delimiter != "" && cmd.nomanifest {
+		return nil, fmt.Errorf("--delimiter requires a signed manifest (the delimiter is recorded in the manifest)")
+	} else if cmd.sheet != "" && cmd.nomanifest {
+		return nil, fmt.Errorf("--sheet requires a signed manifest (the worksheet is recorded in the manifest)")
+	} else if groups != nil {
+		files["groups"] = groups
+	}
+
+	if cmd.nomanifest {
+		signature, err := sign(acl, cmd.keyfile, cmd.passphrase, cmd.signature)
+		if err != nil {
+			return nil, err
+		}
+
+		files["signature"] = signature
+
+		return files, nil
+	}
+
+	now := time.Now()
+	sequence := cmd.sequence
+	if sequence == 0 {
+		sequence = uint64(now.Unix())
+	}
+
+	format := fileFormat{
+		name: aclFormat(filename),
+	}
+
+	switch format.name {
+	case formatCSV:
+		if _, err := parseDelimiter(cmd.delimiter); err != nil {
+			return nil, err
+		}
+
+		format.delimiter = cmd.delimiter
+
+	case formatXLSX:
+		format.sheet = cmd.sheet
+	}
+
+	manifest, err := makeManifest(sequence, now, format, files)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := sign(manifest, cmd.keyfile, cmd.passphrase, cmd.signature)
+	if err != nil {
+		return nil, err
+	}
+
+	files["manifest"] = manifest
+	files["signature"] = signature
+
+	return files, nil
+}
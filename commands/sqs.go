@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/uhppoted/uhppoted-app-s3/log"
+)
+
+// S3 event notification, either delivered directly to the SQS queue or wrapped in an SNS notification
+// (for S3 -> SNS -> SQS fan-out).
+type s3event struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+var sqsHost = regexp.MustCompile(`^sqs\.(.+?)\.amazonaws\.com`)
+
+// Long-polls the SQS queue for S3 ObjectCreated notifications for the ACL file, signalling the notify
+// channel for each matching notification until the context is cancelled. All received messages are
+// deleted, so the queue should only be used for the ACL notifications.
+func listen(ctx context.Context, queue string, uri string, config, profile, region string, notify chan<- string) {
+	q, err := url.Parse(queue)
+	if err != nil {
+		log.Warnf("Invalid SQS queue URL '%v' (%v)", queue, err)
+		return
+	}
+
+	cfg := aws.NewConfig().
+		WithCredentials(credentials.NewSharedCredentials(config, profile)).
+		WithRegion(region)
+
+	// ... use the queue URL region for AWS queues (the queue is only reachable in its own region, which
+	//     may differ from the S3 region) and the queue URL endpoint for anything else (e.g. ElasticMQ)
+	if match := sqsHost.FindStringSubmatch(q.Host); match != nil {
+		cfg = cfg.WithRegion(match[1])
+	} else {
+		cfg = cfg.WithEndpoint(fmt.Sprintf("%v://%v", q.Scheme, q.Host))
+	}
+
+	svc := sqs.New(session.Must(session.NewSession(cfg)))
+
+	log.Infof("Listening for S3 notifications on %v", queue)
+
+	for ctx.Err() == nil {
+		request := sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queue),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		}
+
+		response, err := svc.ReceiveMessageWithContext(ctx, &request)
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("Error receiving S3 notifications from %v (%v)", queue, err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(15 * time.Second):
+			}
+
+			continue
+		} else if err != nil {
+			return
+		}
+
+		for _, message := range response.Messages {
+			if key, ok := matches(aws.StringValue(message.Body), uri); ok {
+				select {
+				case notify <- key:
+				default:
+				}
+			}
+
+			if _, err := svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(queue),
+				ReceiptHandle: message.ReceiptHandle,
+			}); err != nil && ctx.Err() == nil {
+				log.Warnf("Error deleting S3 notification from %v (%v)", queue, err)
+			}
+		}
+	}
+}
+
+// Returns the S3 object if the message is an ObjectCreated notification for the ACL file. The ACL
+// file is matched by bucket and key for s3:// URLs and by the key (URL path) for any other URL.
+func matches(body string, uri string) (string, bool) {
+	var event s3event
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		log.Debugf("Ignoring SQS message (%v)", err)
+		return "", false
+	}
+
+	if event.Type == "Notification" && event.Message != "" {
+		return matches(event.Message, uri)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", false
+	}
+
+	for _, record := range event.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+
+		bucket := record.S3.Bucket.Name
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			continue
+		}
+
+		object := fmt.Sprintf("s3://%v/%v", bucket, key)
+
+		switch {
+		case u.Scheme == "s3" && u.Host == bucket && strings.TrimPrefix(u.Path, "/") == key:
+			return object, true
+
+		case u.Scheme != "s3" && key != "" && strings.HasSuffix(u.Path, "/"+key):
+			return object, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const STATE_FILE = "uhppoted-app-s3.state"
+
+// Persisted sequence number and issue timestamp of the most recently accepted signed ACL for each
+// ACL source.
+type state map[string]accepted
+
+type accepted struct {
+	Sequence uint64    `json:"sequence"`
+	Issued   time.Time `json:"issued"`
+}
+
+func loadState(file string) (state, error) {
+	s := state{}
+
+	if b, err := os.ReadFile(file); err != nil && errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s state) save(file string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// Returns true if the manifest is older than the last ACL accepted from the source. An ACL without
+// a manifest is regarded as a downgrade if an ACL with a manifest has previously been accepted.
+func (s state) isDowngrade(source string, m *manifest) bool {
+	last, ok := s[source]
+	if !ok {
+		return false
+	}
+
+	if m == nil {
+		return true
+	}
+
+	return last.after(accepted{Sequence: m.Sequence, Issued: m.Issued})
+}
+
+// Records the ACL as accepted from the source, retaining the sequence number and issue timestamp of the
+// newest ACL accepted from the source (i.e. loading an older ACL with --allow-downgrade does not lower
+// the replay and downgrade protection).
+func (s state) accept(source string, m *manifest) bool {
+	v := accepted{
+		Sequence: m.Sequence,
+		Issued:   m.Issued,
+	}
+
+	if last, ok := s[source]; ok && !v.after(last) {
+		return false
+	}
+
+	s[source] = v
+
+	return true
+}
+
+// Returns true if the ACL is newer than the other ACL, by sequence number and then by issue timestamp.
+func (a accepted) after(b accepted) bool {
+	if a.Sequence != b.Sequence {
+		return a.Sequence > b.Sequence
+	}
+
+	return a.Issued.After(b.Issued)
+}
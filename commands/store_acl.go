@@ -1,12 +1,10 @@
 package commands
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	syslog "log"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,9 +22,15 @@ var StoreACLCmd = StoreACL{
 	config:      config.DefaultConfig,
 	workdir:     DEFAULT_WORKDIR,
 	keyfile:     DEFAULT_KEYFILE,
+	signature:   signatureJWS,
+	recipients:  DEFAULT_RECIPIENTS,
 	credentials: DEFAULT_CREDENTIALS,
 	profile:     DEFAULT_PROFILE,
 	region:      DEFAULT_REGION,
+	format:      formatTSV,
+	entry:       "uhppoted",
+	split:       false,
+	policy:      policyAll,
 	withPIN:     false,
 	logFile:     DEFAULT_LOGFILE,
 	logFileSize: DEFAULT_LOGFILESIZE,
@@ -35,13 +39,21 @@ var StoreACLCmd = StoreACL{
 }
 
 type StoreACL struct {
-	url         string
+	urls        destinations
+	policy      string
+	site        string
+	entry       string
+	split       bool
 	config      string
 	workdir     string
 	keyfile     string
+	passphrase  string
+	signature   string
+	recipients  string
 	credentials string
 	profile     string
 	region      string
+	format      string
 	withPIN     bool
 	logFile     string
 	logFileSize int
@@ -57,12 +69,20 @@ func (cmd *StoreACL) Name() string {
 func (cmd *StoreACL) FlagSet() *flag.FlagSet {
 	flagset := flag.NewFlagSet("store-acl", flag.ExitOnError)
 
-	flagset.StringVar(&cmd.url, "url", cmd.url, "URL for a 'PUT' request to upload the retrieved ACL file")
+	flagset.Var(&cmd.urls, "url", "URL for a 'PUT' request to upload the retrieved ACL file (may include {{.Date}}, {{.Time}}, {{.DateTime}}, {{.Hostname}}, {{.Site}} and {{.Controller}} placeholders). May be repeated to upload to multiple destinations")
+	flagset.StringVar(&cmd.policy, "policy", cmd.policy, "Upload policy for multiple --url destinations ('all' fails if any upload fails, 'any' fails only if every upload fails)")
+	flagset.StringVar(&cmd.site, "site", cmd.site, "Site name for the {{.Site}} placeholder")
+	flagset.StringVar(&cmd.entry, "entry", cmd.entry, "ACL file name (without extension) in the uploaded archive (may include placeholders)")
+	flagset.BoolVar(&cmd.split, "split", cmd.split, "Stores the ACL for each controller separately, as a signed archive per controller")
 	flagset.StringVar(&cmd.credentials, "credentials", cmd.credentials, "AWS credentials file")
 	flagset.StringVar(&cmd.profile, "profile", cmd.profile, "AWS credentials file profile (defaults to 'default')")
 	flagset.StringVar(&cmd.region, "region", cmd.region, "AWS region for S3 (defaults to us-east-1)")
-	flagset.StringVar(&cmd.keyfile, "key", cmd.keyfile, "RSA signing key")
+	flagset.StringVar(&cmd.keyfile, "key", cmd.keyfile, "RSA signing key file (or PKCS#11 URI for a key held in a PKCS#11 token)")
+	flagset.StringVar(&cmd.passphrase, "passphrase", cmd.passphrase, "Passphrase for an encrypted RSA signing key (or PKCS#11 token PIN), specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.StringVar(&cmd.signature, "signature", cmd.signature, "Signature format, either 'rsa' (raw RSA signature) or 'jws' (JSON signature envelope). Defaults to 'jws'")
+	flagset.StringVar(&cmd.format, "format", cmd.format, "ACL file format (tsv, csv, json or xlsx)")
 	flagset.BoolVar(&cmd.withPIN, "with-pin", cmd.withPIN, "Includes the card keypad PIN codes in the retrieved ACL file")
+	flagset.StringVar(&cmd.recipients, "recipients", cmd.recipients, "Directory of RSA public keys for which to encrypt an ACL file that includes card PIN codes")
 	flagset.BoolVar(&cmd.nosign, "no-sign", cmd.nosign, "Does not sign the generated report")
 	flagset.BoolVar(&cmd.nolog, "no-log", cmd.nolog, "Writes log messages to stdout rather than a rotatable log file")
 	flagset.StringVar(&cmd.workdir, "workdir", cmd.workdir, "Sets the working directory for temporary files, etc")
@@ -80,9 +100,20 @@ func (cmd *StoreACL) Usage() string {
 
 func (cmd *StoreACL) Help() {
 	fmt.Println()
-	fmt.Printf("  Usage: %s [--debug] [--config <file>] store-acl --url <URL> [--credentials <file>] [--profile <file>] [--region <region>] [--key <file>] [--no-log] [--no-sign]\n", APP)
+	fmt.Printf("  Usage: %s [--debug] [--config <file>] store-acl --url <URL> [--url <URL>...] [--policy all|any] [--site <name>] [--entry <name>] [--split] [--credentials <file>] [--profile <file>] [--region <region>] [--key <file>] [--passphrase <source>] [--signature rsa|jws] [--format <format>] [--with-pin] [--recipients <dir>] [--no-log] [--no-sign]\n", APP)
 	fmt.Println()
-	fmt.Println("    Retrieves the ACL from the controllers configured in the configuration file and stores it to the provided URL")
+	fmt.Println("    Retrieves the ACL from the controllers configured in the configuration file and stores it to the provided URL.")
+	fmt.Println("    ACL files that include card PIN codes are encrypted for the public keys in the --recipients directory.")
+	fmt.Println()
+	fmt.Println("    The URL and archive entry name may include {{.Date}}, {{.Time}}, {{.DateTime}}, {{.Hostname}}, {{.Site}} and")
+	fmt.Println("    {{.Controller}} placeholders, e.g. s3://uhppoted/{{.Site}}/{{.Date}}/acl.tar.gz for a dated history per site.")
+	fmt.Println()
+	fmt.Println("    The --split option stores the ACL for each controller separately, as a signed archive per controller")
+	fmt.Println("    (with a {{.Controller}} placeholder in the URL).")
+	fmt.Println()
+	fmt.Println("    The --url option may be repeated to upload the signed archive to multiple destinations (e.g. S3 and a local")
+	fmt.Println("    file:// archive). The --policy option determines whether store-acl fails if any upload fails ('all', the")
+	fmt.Println("    default) or only if every upload fails ('any').")
 	fmt.Println()
 
 	helpOptions(cmd.FlagSet())
@@ -91,13 +122,20 @@ func (cmd *StoreACL) Help() {
 }
 
 func (cmd *StoreACL) Execute(args ...interface{}) error {
-	if strings.TrimSpace(cmd.url) == "" {
+	if len(cmd.urls) == 0 {
 		return fmt.Errorf("store-acl requires a pre-signed S3 URL in the command options")
 	}
 
-	uri, err := url.Parse(cmd.url)
-	if err != nil {
-		return fmt.Errorf("invalid upload URL '%s' (%w)", cmd.url, err)
+	if err := checkPolicy(cmd.policy); err != nil {
+		return err
+	}
+
+	if _, ok := extensions[cmd.format]; !ok {
+		return fmt.Errorf("invalid ACL file format '%v'", cmd.format)
+	}
+
+	if err := checkSignatureFormat(cmd.signature); err != nil {
+		return err
 	}
 
 	conf := config.NewConfig()
@@ -141,12 +179,10 @@ func (cmd *StoreACL) Execute(args ...interface{}) error {
 		}()
 	}
 
-	return cmd.execute(u, uri.String(), devices)
+	return cmd.execute(u, newPlaceholders(cmd.site, devices), devices)
 }
 
-func (cmd *StoreACL) execute(u uhppote.IUHPPOTE, uri string, devices []uhppote.Device) error {
-	log.Infof("Storing ACL to %v", uri)
-
+func (cmd *StoreACL) execute(u uhppote.IUHPPOTE, values placeholders, devices []uhppote.Device) error {
 	list, errors := acl.GetACL(u, devices)
 	if len(errors) > 0 {
 		return fmt.Errorf("%v", errors)
@@ -156,45 +192,114 @@ func (cmd *StoreACL) execute(u uhppote.IUHPPOTE, uri string, devices []uhppote.D
 		log.Infof("%v  Retrieved %v records", k, len(l))
 	}
 
-	var w strings.Builder
+	if cmd.split {
+		return cmd.storeArchives(list, values, devices)
+	}
+
+	uris, err := cmd.urls.expand(values)
+	if err != nil {
+		return err
+	}
+
+	filename, err := values.entry(cmd.entry, extensions[cmd.format])
+	if err != nil {
+		return err
+	}
+
+	return cmd.store(uris, filename, list, devices)
+}
+
+// Stores the ACL for each controller to a separate archive, at the URLs for the controller. The URLs must
+// include the {{.Controller}} placeholder (or otherwise be unique for each controller).
+func (cmd *StoreACL) storeArchives(list acl.ACL, values placeholders, devices []uhppote.Device) error {
+	urls := map[string]uint32{}
+	uploads := map[uint32][]string{}
+	filenames := map[uint32]string{}
+
+	for _, d := range devices {
+		v := values.forController(d)
+
+		uris, err := cmd.urls.expand(v)
+		if err != nil {
+			return err
+		}
+
+		for _, uri := range uris {
+			if id, ok := urls[uri]; ok {
+				return fmt.Errorf("controllers %v and %v have the same upload URL (%v) - the URL requires a {{.Controller}} placeholder", id, d.DeviceID, uri)
+			}
 
-	makeTSV := func() error {
-		if cmd.withPIN {
-			return acl.MakeTSVWithPIN(list, devices, &w)
-		} else {
-			return acl.MakeTSV(list, devices, &w)
+			urls[uri] = d.DeviceID
+		}
+
+		filename, err := v.entry(cmd.entry, extensions[cmd.format])
+		if err != nil {
+			return err
+		}
+
+		uploads[d.DeviceID] = uris
+		filenames[d.DeviceID] = filename
+	}
+
+	errors := []error{}
+	for _, d := range devices {
+		id := d.DeviceID
+		if err := cmd.store(uploads[id], filenames[id], acl.ACL{id: list[id]}, []uhppote.Device{d}); err != nil {
+			log.Warnf("%v  Error storing ACL (%v)", id, err)
+			errors = append(errors, err)
 		}
 	}
 
-	if err := makeTSV(); err != nil {
+	if len(errors) > 0 {
+		return fmt.Errorf("%v", errors)
+	}
+
+	return nil
+}
+
+func (cmd *StoreACL) store(uris []string, filename string, list acl.ACL, devices []uhppote.Device) error {
+	log.Infof("Storing ACL to %v", strings.Join(uris, ", "))
+
+	name, body, err := cmd.makeFile(filename, list, devices)
+	if err != nil {
 		return err
 	}
 
-	tsv := []byte(w.String())
 	files := map[string][]byte{
-		"uhppoted.acl": tsv,
+		name: body,
 	}
 
 	if !cmd.nosign {
-		signature, err := sign(tsv, cmd.keyfile)
+		signature, err := sign(body, cmd.keyfile, cmd.passphrase, cmd.signature)
 		if err != nil {
 			return err
 		}
 		files["signature"] = signature
 	}
 
-	var b bytes.Buffer
-	x := targz
-	if strings.HasSuffix(uri, ".zip") {
-		x = zipf
-	}
+	return uploadAll(uris, files, cmd.policy, cmd.put)
+}
 
-	if err := x(files, &b); err != nil {
-		return err
+// Creates the ACL file for the controllers, returning the archive entry name and content. ACL files
+// that include card PIN codes are encrypted for the --recipients and are never stored unencrypted.
+func (cmd *StoreACL) makeFile(filename string, list acl.ACL, devices []uhppote.Device) (string, []byte, error) {
+	tsv, err := makeACL(list, devices, cmd.format, cmd.withPIN)
+	if err != nil {
+		return "", nil, err
 	}
 
-	log.Infof("tar'd ACL (%v bytes) and signature (%v bytes): %v bytes", len(files["uhppoted.acl"]), len(files["signature"]), b.Len())
+	if !cmd.withPIN {
+		return filename, tsv, nil
+	} else if _, err := os.Stat(cmd.recipients); err != nil {
+		return "", nil, fmt.Errorf("--with-pin requires an ACL recipients directory for the encryption keys (%w)", err)
+	} else if encrypted, err := encrypt(tsv, cmd.recipients); err != nil {
+		return "", nil, err
+	} else {
+		return filename + ".enc", encrypted, nil
+	}
+}
 
+func (cmd *StoreACL) put(uri string, r io.Reader) error {
 	f := cmd.storeHTTP
 	if strings.HasPrefix(uri, "s3://") {
 		f = cmd.storeS3
@@ -202,13 +307,7 @@ func (cmd *StoreACL) execute(u uhppote.IUHPPOTE, uri string, devices []uhppote.D
 		f = cmd.storeFile
 	}
 
-	if err := f(uri, bytes.NewReader(b.Bytes())); err != nil {
-		return err
-	}
-
-	log.Infof("Stored ACL to %v", uri)
-
-	return nil
+	return f(uri, r)
 }
 
 func (cmd *StoreACL) storeHTTP(url string, r io.Reader) error {
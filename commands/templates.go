@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/uhppoted/uhppote-core/uhppote"
+)
+
+// Values for the placeholders in templated upload URLs and archive entry names, e.g.
+//
+//	s3://uhppoted/{{.Site}}/{{.Date}}/{{.Hostname}}-{{.Time}}.tar.gz
+//
+// Controller is the controller ID (or the IDs of all the controllers joined with '-').
+type placeholders struct {
+	Date       string
+	Time       string
+	DateTime   string
+	Hostname   string
+	Site       string
+	Controller string
+}
+
+func newPlaceholders(site string, devices []uhppote.Device) placeholders {
+	now := time.Now()
+	hostname, _ := os.Hostname()
+
+	controllers := []string{}
+	for _, d := range devices {
+		controllers = append(controllers, fmt.Sprintf("%v", d.DeviceID))
+	}
+
+	return placeholders{
+		Date:       now.Format("2006-01-02"),
+		Time:       now.Format("150405"),
+		DateTime:   now.Format("2006-01-02T150405"),
+		Hostname:   hostname,
+		Site:       site,
+		Controller: strings.Join(controllers, "-"),
+	}
+}
+
+// Returns the placeholder values for a single controller.
+func (p placeholders) forController(d uhppote.Device) placeholders {
+	p.Controller = fmt.Sprintf("%v", d.DeviceID)
+
+	return p
+}
+
+// Replaces the placeholders in a URL or archive entry name. Strings without placeholders are
+// returned unchanged.
+func (p placeholders) expand(s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	t, err := template.New("placeholders").Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid template '%v' (%w)", s, err)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, p); err != nil {
+		return "", fmt.Errorf("invalid template '%v' (%w)", s, err)
+	}
+
+	return b.String(), nil
+}
+
+// Returns the archive entry name for the (templated) name and file extension.
+func (p placeholders) entry(name string, extension string) (string, error) {
+	s, err := p.expand(name)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(s) == "" || strings.ContainsAny(s, `/\`) {
+		return "", fmt.Errorf("invalid archive entry name '%v'", s)
+	}
+
+	return s + extension, nil
+}
@@ -0,0 +1,402 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uhppoted/uhppote-core/uhppote"
+	"github.com/uhppoted/uhppoted-lib/acl"
+	"github.com/uhppoted/uhppoted-lib/config"
+)
+
+var ValidateACLCmd = ValidateACL{
+	config:      config.DefaultConfig,
+	keysdir:     DEFAULT_KEYSDIR,
+	sitekey:     DEFAULT_SITEKEY,
+	credentials: DEFAULT_CREDENTIALS,
+	profile:     DEFAULT_PROFILE,
+	region:      DEFAULT_REGION,
+	noverify:    false,
+}
+
+type ValidateACL struct {
+	url            string
+	config         string
+	keysdir        string
+	sitekey        string
+	sitePassphrase string
+	credentials    string
+	profile        string
+	region         string
+	timeProfiles   string
+	noverify       bool
+	pins           pins
+}
+
+type lint struct {
+	errors   []string
+	warnings []string
+}
+
+func (cmd *ValidateACL) Name() string {
+	return "validate-acl"
+}
+
+func (cmd *ValidateACL) FlagSet() *flag.FlagSet {
+	flagset := flag.NewFlagSet("validate-acl", flag.ExitOnError)
+
+	flagset.StringVar(&cmd.url, "url", cmd.url, "The URL of the signed ACL archive")
+	flagset.StringVar(&cmd.credentials, "credentials", cmd.credentials, "AWS credentials file")
+	flagset.StringVar(&cmd.profile, "profile", cmd.profile, "AWS credentials file profile (defaults to 'default')")
+	flagset.StringVar(&cmd.region, "region", cmd.region, "AWS region for S3 (defaults to us-east-1)")
+	flagset.StringVar(&cmd.keysdir, "keys", cmd.keysdir, "Sets the directory to search for RSA signing keys. Key files are expected to be named '<uname>.pub'")
+	flagset.StringVar(&cmd.sitekey, "site-key", cmd.sitekey, "RSA private key for decrypting encrypted ACL files")
+	flagset.StringVar(&cmd.sitePassphrase, "site-key-passphrase", cmd.sitePassphrase, "Passphrase for an encrypted site private key, specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.StringVar(&cmd.timeProfiles, "time-profiles", cmd.timeProfiles, "Comma separated list of the time profile IDs defined on the controllers (time profile references are not checked if not specified)")
+	flagset.BoolVar(&cmd.noverify, "no-verify", cmd.noverify, "Disables verification of the ACL archive signature")
+
+	return flagset
+}
+
+func (cmd *ValidateACL) Description() string {
+	return "Validates an ACL archive against the configured controllers without updating the controllers"
+}
+
+func (cmd *ValidateACL) Usage() string {
+	return "validate-acl --url <URL>"
+}
+
+func (cmd *ValidateACL) Help() {
+	fmt.Println()
+	fmt.Printf("  Usage: %s [--config <file>] validate-acl --url <URL> [--keys <dir>] [--site-key <file>] [--site-key-passphrase <source>] [--time-profiles <IDs>] [--credentials <file>] [--profile <file>] [--region <region>] [--no-verify]\n", APP)
+	fmt.Println()
+	fmt.Println("    Fetches, unpacks and verifies the ACL archive at the URL and checks the ACL against the devices and doors in")
+	fmt.Println("    the configuration file without contacting the controllers, reporting duplicate cards, unknown door columns,")
+	fmt.Println("    invalid dates and PINs, expired cards and invalid time profiles. Exits with a non-zero exit code if the ACL")
+	fmt.Println("    is not valid.")
+	fmt.Println()
+
+	helpOptions(cmd.FlagSet())
+	fmt.Println()
+}
+
+func (cmd *ValidateACL) Execute(args ...interface{}) error {
+	options := args[0].(*Options)
+
+	cmd.config = options.Config
+
+	if strings.TrimSpace(cmd.url) == "" {
+		return fmt.Errorf("validate-acl requires a URL for the signed ACL archive")
+	}
+
+	uri, err := url.Parse(cmd.url)
+	if err != nil {
+		return fmt.Errorf("invalid ACL file URL '%s' (%w)", cmd.url, err)
+	}
+
+	profiles, err := parseTimeProfiles(cmd.timeProfiles)
+	if err != nil {
+		return err
+	}
+
+	conf := config.NewConfig()
+	if cmd.pins, err = loadConfig(cmd.config, conf); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("WARN  Could not load configuration (%v)", err)
+	}
+
+	if cmd.credentials == "" {
+		cmd.credentials = conf.AWS.Credentials
+	}
+
+	if cmd.profile == "" {
+		cmd.profile = conf.AWS.Profile
+	}
+
+	if cmd.region == "" {
+		cmd.region = conf.AWS.Region
+	}
+
+	return cmd.execute(uri.String(), conf.Devices.ToControllers(), profiles)
+}
+
+func (cmd *ValidateACL) execute(uri string, devices []uhppote.Device, profiles map[int]bool) error {
+	f := cmd.fetchHTTP
+	if strings.HasPrefix(uri, "s3://") {
+		f = cmd.fetchS3
+	} else if strings.HasPrefix(uri, "file://") {
+		f = cmd.fetchFile
+	}
+
+	b, err := f(uri)
+	if err != nil {
+		return err
+	}
+
+	a, err := unpack(uri, b)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("  Archive    %v\n", uri)
+	fmt.Printf("  ACL        %v\n", a.name)
+
+	if cmd.noverify {
+		fmt.Printf("  Signature  NOT VERIFIED\n")
+	} else if _, signer, err := a.verify(cmd.keysdir); err != nil {
+		fmt.Printf("  Signature  INVALID\n")
+		fmt.Println()
+
+		return err
+	} else if err := cmd.pins.check(uri, signer); err != nil {
+		fmt.Printf("  Signature  INVALID\n")
+		fmt.Println()
+
+		return err
+	} else {
+		fmt.Printf("  Signed by  %v\n", signer)
+		fmt.Printf("  Signature  OK\n")
+	}
+
+	body := a.acl
+	if a.encrypted {
+		if body, err = decrypt(a.acl, cmd.sitekey, cmd.sitePassphrase); err != nil {
+			return err
+		}
+	}
+
+	l := cmd.validate(a, body, devices, profiles)
+
+	fmt.Println()
+	for _, e := range l.errors {
+		fmt.Printf("  ERROR      %v\n", e)
+	}
+
+	for _, w := range l.warnings {
+		fmt.Printf("  WARNING    %v\n", w)
+	}
+
+	if len(l.errors) > 0 || len(l.warnings) > 0 {
+		fmt.Println()
+	}
+
+	fmt.Printf("  Errors     %v\n", len(l.errors))
+	fmt.Printf("  Warnings   %v\n", len(l.warnings))
+	fmt.Println()
+
+	if len(l.errors) > 0 {
+		return fmt.Errorf("invalid ACL (%v errors)", len(l.errors))
+	}
+
+	return nil
+}
+
+// Checks the ACL (or the cards added and updated by a delta ACL) and then parses it with the same
+// parser as load-acl to catch anything the checks do not cover.
+func (cmd *ValidateACL) validate(a *archive, b []byte, devices []uhppote.Device, profiles map[int]bool) lint {
+	l := lint{
+		errors:   []string{},
+		warnings: []string{},
+	}
+
+	g, err := a.groupDefinitions()
+	if err != nil {
+		l.errors = append(l.errors, err.Error())
+		return l
+	}
+
+	var format fileFormat
+	var table *acl.Table
+
+	if a.delta {
+		if d, err := parseDelta(b); err != nil {
+			l.errors = append(l.errors, err.Error())
+			return l
+		} else {
+			table = cardsTable(slices.Concat(d.Add, d.Update))
+		}
+	} else if format, err = a.format(); err != nil {
+		l.errors = append(l.errors, err.Error())
+		return l
+	} else if table, err = parseTable(b, format); err != nil {
+		l.errors = append(l.errors, err.Error())
+		return l
+	}
+
+	table = withoutHolder(table)
+
+	if g != nil {
+		if table, err = g.expand(table); err != nil {
+			l.errors = append(l.errors, err.Error())
+			return l
+		}
+	}
+
+	fmt.Printf("  Records    %v\n", len(table.Records))
+
+	l.check(table, devices, profiles, !a.delta, time.Now())
+
+	if len(l.errors) == 0 && !a.delta {
+		if _, _, err := parseACL(b, format, g, devices, true); err != nil {
+			l.errors = append(l.errors, err.Error())
+		}
+	}
+
+	return l
+}
+
+// Checks the ACL table header and records. Missing door columns are only reported for a full ACL
+// since delta ACL cards only list the doors to which they have access.
+func (l *lint) check(table *acl.Table, devices []uhppote.Device, profiles map[int]bool, full bool, now time.Time) {
+	doors := map[string]bool{}
+	for _, d := range devices {
+		for _, door := range d.Doors {
+			if normalise(door) != "" {
+				doors[normalise(door)] = true
+			}
+		}
+	}
+
+	columns := map[string]int{}
+	for i, h := range table.Header {
+		key := normalise(h)
+
+		if _, ok := columns[key]; ok {
+			l.errors = append(l.errors, fmt.Sprintf("duplicate column '%v'", h))
+			continue
+		}
+
+		columns[key] = i
+
+		switch key {
+		case "cardnumber", "pin", "from", "to":
+		default:
+			if !doors[key] {
+				l.errors = append(l.errors, fmt.Sprintf("no configured door matches column '%v'", h))
+			}
+		}
+	}
+
+	missing := false
+	for _, c := range []string{"Card Number", "From", "To"} {
+		if _, ok := columns[normalise(c)]; !ok {
+			l.errors = append(l.errors, fmt.Sprintf("missing '%v' column", c))
+			missing = true
+		}
+	}
+
+	if missing {
+		return
+	}
+
+	for _, d := range devices {
+		for _, door := range d.Doors {
+			if _, ok := columns[normalise(door)]; full && normalise(door) != "" && !ok {
+				l.warnings = append(l.warnings, fmt.Sprintf("no column for door '%v' (%v) - cards will not have access", door, d.DeviceID))
+			}
+		}
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	cards := map[uint64]int{}
+
+	for i, record := range table.Records {
+		row := i + 1
+		errorf := func(format string, args ...any) {
+			l.errors = append(l.errors, fmt.Sprintf("row %v: %v", row, fmt.Sprintf(format, args...)))
+		}
+
+		warnf := func(format string, args ...any) {
+			l.warnings = append(l.warnings, fmt.Sprintf("row %v: %v", row, fmt.Sprintf(format, args...)))
+		}
+
+		card := strings.TrimSpace(field(record, columns["cardnumber"]))
+		if v, err := strconv.ParseUint(card, 10, 32); err != nil {
+			errorf("invalid card number '%v'", card)
+		} else if first, ok := cards[v]; ok {
+			errorf("duplicate card number %v (also in row %v)", v, first)
+		} else {
+			cards[v] = row
+		}
+
+		if ix, ok := columns["pin"]; ok {
+			if pin := strings.TrimSpace(field(record, ix)); pin != "" {
+				if v, err := strconv.ParseUint(pin, 10, 32); err != nil || v > 999999 {
+					errorf("card %v has an invalid PIN '%v'", card, pin)
+				}
+			}
+		}
+
+		from, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(field(record, columns["from"])), time.Local)
+		if err != nil {
+			errorf("card %v has an invalid 'from' date '%v'", card, field(record, columns["from"]))
+		}
+
+		to, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(field(record, columns["to"])), time.Local)
+		if err != nil {
+			errorf("card %v has an invalid 'to' date '%v'", card, field(record, columns["to"]))
+		} else if to.Before(today) {
+			warnf("card %v expired on %v", card, to.Format("2006-01-02"))
+		}
+
+		if !from.IsZero() && !to.IsZero() && to.Before(from) {
+			errorf("card %v 'to' date %v is before the 'from' date %v", card, to.Format("2006-01-02"), from.Format("2006-01-02"))
+		}
+
+		for i, h := range table.Header {
+			switch normalise(h) {
+			case "cardnumber", "pin", "from", "to":
+				continue
+			}
+
+			switch v := strings.TrimSpace(field(record, i)); v {
+			case "Y", "N":
+
+			default:
+				if profile, err := strconv.Atoi(v); err != nil {
+					errorf("card %v has an invalid permission '%v' for door '%v' (expected Y, N or a time profile ID)", card, v, h)
+				} else if profile < 2 || profile > 254 {
+					errorf("card %v has an invalid time profile %v for door '%v' (valid profiles are in the interval [2..254])", card, v, h)
+				} else if profiles != nil && !profiles[profile] {
+					errorf("card %v has an undefined time profile %v for door '%v'", card, v, h)
+				}
+			}
+		}
+	}
+}
+
+func parseTimeProfiles(s string) (map[int]bool, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	profiles := map[int]bool{}
+	for _, v := range split(s) {
+		if profile, err := strconv.Atoi(v); err != nil || profile < 2 || profile > 254 {
+			return nil, fmt.Errorf("invalid time profile ID '%v'", v)
+		} else {
+			profiles[profile] = true
+		}
+	}
+
+	return profiles, nil
+}
+
+func (cmd *ValidateACL) fetchHTTP(url string) ([]byte, error) {
+	return fetchHTTP(url)
+}
+
+func (cmd *ValidateACL) fetchS3(url string) ([]byte, error) {
+	return fetchS3(url, cmd.credentials, cmd.profile, cmd.region)
+}
+
+func (cmd *ValidateACL) fetchFile(url string) ([]byte, error) {
+	return fetchFile(url)
+}
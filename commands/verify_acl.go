@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/uhppoted/uhppoted-lib/config"
+)
+
+var VerifyACLCmd = VerifyACL{
+	config:      config.DefaultConfig,
+	keysdir:     DEFAULT_KEYSDIR,
+	credentials: DEFAULT_CREDENTIALS,
+	profile:     DEFAULT_PROFILE,
+	region:      DEFAULT_REGION,
+}
+
+type VerifyACL struct {
+	url         string
+	config      string
+	keysdir     string
+	credentials string
+	profile     string
+	region      string
+	pins        pins
+}
+
+func (cmd *VerifyACL) Name() string {
+	return "verify-acl"
+}
+
+func (cmd *VerifyACL) FlagSet() *flag.FlagSet {
+	flagset := flag.NewFlagSet("verify-acl", flag.ExitOnError)
+
+	flagset.StringVar(&cmd.url, "url", cmd.url, "The URL of the signed ACL archive")
+	flagset.StringVar(&cmd.credentials, "credentials", cmd.credentials, "AWS credentials file")
+	flagset.StringVar(&cmd.profile, "profile", cmd.profile, "AWS credentials file profile (defaults to 'default')")
+	flagset.StringVar(&cmd.region, "region", cmd.region, "AWS region for S3 (defaults to us-east-1)")
+	flagset.StringVar(&cmd.keysdir, "keys", cmd.keysdir, "Sets the directory to search for RSA signing keys. Key files are expected to be named '<uname>.pub'")
+
+	return flagset
+}
+
+func (cmd *VerifyACL) Description() string {
+	return "Verifies the signature of an ACL archive"
+}
+
+func (cmd *VerifyACL) Usage() string {
+	return "verify-acl --url <URL>"
+}
+
+func (cmd *VerifyACL) Help() {
+	fmt.Println()
+	fmt.Printf("  Usage: %s [--config <file>] verify-acl --url <URL> [--keys <dir>] [--credentials <file>] [--profile <file>] [--region <region>]\n", APP)
+	fmt.Println()
+	fmt.Println("    Fetches the ACL archive at the URL and verifies the signature against the public keys in the keys")
+	fmt.Println("    directory, printing the signer, file digests and verification result.")
+	fmt.Println()
+
+	helpOptions(cmd.FlagSet())
+	fmt.Println()
+}
+
+func (cmd *VerifyACL) Execute(args ...interface{}) error {
+	options := args[0].(*Options)
+
+	cmd.config = options.Config
+
+	if strings.TrimSpace(cmd.url) == "" {
+		return fmt.Errorf("verify-acl requires a URL for the signed ACL archive")
+	}
+
+	uri, err := url.Parse(cmd.url)
+	if err != nil {
+		return fmt.Errorf("invalid ACL file URL '%s' (%w)", cmd.url, err)
+	}
+
+	conf := config.NewConfig()
+	if cmd.pins, err = loadConfig(cmd.config, conf); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("WARN  Could not load configuration (%v)", err)
+	}
+
+	if cmd.credentials == "" {
+		cmd.credentials = conf.AWS.Credentials
+	}
+
+	if cmd.profile == "" {
+		cmd.profile = conf.AWS.Profile
+	}
+
+	if cmd.region == "" {
+		cmd.region = conf.AWS.Region
+	}
+
+	return cmd.execute(uri.String())
+}
+
+func (cmd *VerifyACL) execute(uri string) error {
+	f := cmd.fetchHTTP
+	if strings.HasPrefix(uri, "s3://") {
+		f = cmd.fetchS3
+	} else if strings.HasPrefix(uri, "file://") {
+		f = cmd.fetchFile
+	}
+
+	b, err := f(uri)
+	if err != nil {
+		return err
+	}
+
+	a, err := unpack(uri, b)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("  Archive    %v\n", uri)
+	fmt.Printf("             %v bytes  SHA-256:%x\n", len(b), sha256.Sum256(b))
+	fmt.Printf("  ACL        %v\n", a.name)
+	fmt.Printf("             %v bytes  SHA-256:%x\n", len(a.acl), sha256.Sum256(a.acl))
+	if a.manifest != nil {
+		fmt.Printf("  Manifest   %v bytes  SHA-256:%x\n", len(a.manifest), sha256.Sum256(a.manifest))
+	}
+	fmt.Printf("  Labelled   %v\n", a.uname)
+
+	m, signer, err := a.verify(cmd.keysdir)
+	if signer != nil {
+		fmt.Printf("  Signed by  %v\n", signer.Name)
+		fmt.Printf("  Key ID     %v\n", signer.KeyID)
+
+		if err == nil {
+			err = cmd.pins.check(uri, signer)
+		}
+	}
+
+	if m != nil {
+		fmt.Printf("  Sequence   %v\n", m.Sequence)
+		fmt.Printf("  Issued     %v\n", m.Issued.Format(time.RFC3339))
+	}
+
+	if err != nil {
+		fmt.Printf("  Signature  INVALID\n")
+		fmt.Println()
+
+		return err
+	}
+
+	fmt.Printf("  Signature  OK\n")
+	if signer.Name != a.uname {
+		fmt.Printf("  WARNING    archive is labelled as signed by '%v' but was signed by '%v'\n", a.uname, signer.Name)
+	}
+	fmt.Println()
+
+	return nil
+}
+
+func (cmd *VerifyACL) fetchHTTP(url string) ([]byte, error) {
+	return fetchHTTP(url)
+}
+
+func (cmd *VerifyACL) fetchS3(url string) ([]byte, error) {
+	return fetchS3(url, cmd.credentials, cmd.profile, cmd.region)
+}
+
+func (cmd *VerifyACL) fetchFile(url string) ([]byte, error) {
+	return fetchFile(url)
+}
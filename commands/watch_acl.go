@@ -0,0 +1,437 @@
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	syslog "log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/uhppoted/uhppote-core/uhppote"
+	"github.com/uhppoted/uhppoted-lib/config"
+	"github.com/uhppoted/uhppoted-lib/eventlog"
+	"github.com/uhppoted/uhppoted-lib/lockfile"
+
+	"github.com/uhppoted/uhppoted-app-s3/log"
+)
+
+var WatchACLCmd = WatchACL{
+	config:         config.DefaultConfig,
+	workdir:        DEFAULT_WORKDIR,
+	keysdir:        DEFAULT_KEYSDIR,
+	sitekey:        DEFAULT_SITEKEY,
+	credentials:    DEFAULT_CREDENTIALS,
+	profile:        DEFAULT_PROFILE,
+	region:         DEFAULT_REGION,
+	logFile:        DEFAULT_LOGFILE,
+	logFileSize:    DEFAULT_LOGFILESIZE,
+	interval:       1 * time.Minute,
+	reconcile:      1 * time.Hour,
+	settle:         5 * time.Second,
+	withPIN:        false,
+	dryrun:         false,
+	strict:         false,
+	noreport:       false,
+	noverify:       false,
+	allowDowngrade: false,
+	nolog:          false,
+	debug:          false,
+}
+
+type WatchACL struct {
+	url            string
+	sqs            string
+	config         string
+	workdir        string
+	keysdir        string
+	sitekey        string
+	sitePassphrase string
+	credentials    string
+	profile        string
+	region         string
+	logFile        string
+	logFileSize    int
+	interval       time.Duration
+	reconcile      time.Duration
+	settle         time.Duration
+	withPIN        bool
+	dryrun         bool
+	strict         bool
+	noreport       bool
+	noverify       bool
+	allowDowngrade bool
+	nolog          bool
+	debug          bool
+}
+
+func (cmd *WatchACL) Name() string {
+	return "watch-acl"
+}
+
+func (cmd *WatchACL) FlagSet() *flag.FlagSet {
+	flagset := flag.NewFlagSet("watch-acl", flag.ExitOnError)
+
+	flagset.StringVar(&cmd.url, "url", cmd.url, "The URL from which to fetch the ACL file")
+	flagset.StringVar(&cmd.sqs, "sqs", cmd.sqs, "SQS queue URL for S3 ObjectCreated notifications that trigger an immediate load of the ACL file")
+	flagset.DurationVar(&cmd.settle, "settle", cmd.settle, "Interval for which a file:// ACL file must be unchanged before it is loaded")
+	flagset.DurationVar(&cmd.interval, "interval", cmd.interval, "Interval at which to check the ACL file for changes (0 disables polling if --sqs is specified)")
+	flagset.DurationVar(&cmd.reconcile, "reconcile", cmd.reconcile, "Interval at which to reload the ACL file to correct any changes to the controllers (0 to disable)")
+	flagset.StringVar(&cmd.credentials, "credentials", cmd.credentials, "AWS credentials file")
+	flagset.StringVar(&cmd.profile, "profile", cmd.profile, "AWS credentials file profile (defaults to 'default')")
+	flagset.StringVar(&cmd.region, "region", cmd.region, "AWS region for S3 (defaults to us-east-1)")
+	flagset.StringVar(&cmd.keysdir, "keys", cmd.keysdir, "Sets the directory to search for RSA signing keys. Key files are expected to be named '<uname>.pub'")
+	flagset.StringVar(&cmd.sitekey, "site-key", cmd.sitekey, "RSA private key for decrypting encrypted ACL files")
+	flagset.StringVar(&cmd.sitePassphrase, "site-key-passphrase", cmd.sitePassphrase, "Passphrase for an encrypted site private key, specified as file:<path>, env:<variable> or credential:<systemd credential>")
+	flagset.StringVar(&cmd.workdir, "workdir", cmd.workdir, "Sets the working directory for temporary files, etc")
+	flagset.BoolVar(&cmd.withPIN, "with-pin", cmd.withPIN, "Includes the card keypad PIN codes when updating the controllers")
+	flagset.BoolVar(&cmd.noverify, "no-verify", cmd.noverify, "Disables verification of the downloaded ACL RSA signature")
+	flagset.BoolVar(&cmd.allowDowngrade, "allow-downgrade", cmd.allowDowngrade, "Allows loading a signed ACL that is older than the last ACL loaded from the same URL")
+	flagset.BoolVar(&cmd.dryrun, "dry-run", cmd.dryrun, "Simulates loading the ACL without making any changes to the access controllers")
+	flagset.BoolVar(&cmd.strict, "strict", cmd.strict, "Fails the load if the ACL contains duplicate card numbers")
+	flagset.BoolVar(&cmd.noreport, "no-report", cmd.noreport, "Disables ACL 'diff' report")
+	flagset.BoolVar(&cmd.nolog, "no-log", cmd.nolog, "Writes log messages to stdout rather than a rotatable log file")
+
+	return flagset
+}
+
+func (cmd *WatchACL) Description() string {
+	return "Watches an access control list and loads it to the configured controllers whenever it changes"
+}
+
+func (cmd *WatchACL) Usage() string {
+	return "watch-acl [--debug] --url <S3 URL>"
+}
+
+func (cmd *WatchACL) Help() {
+	fmt.Println()
+	fmt.Printf("  Usage: %s [--debug] [--config <file>] watch-acl --url <URL> [--sqs <URL>] [--interval <duration>] [--reconcile <duration>] [--settle <duration>] [--dry-run] [--credentials <file>] [--profile <file>] [--region <region>] [--keys <dir>] [--site-key <file>] [--site-key-passphrase <source>] [--workdir <dir>] [--strict] [--no-verify] [--allow-downgrade] [--no-log] [--no-report]\n", APP)
+	fmt.Println()
+	fmt.Println("    Runs until terminated, checking the ACL file at the URL for changes at the --interval and loading it to the")
+	fmt.Println("    controllers configured in the configuration file whenever it changes. Changes are detected using the HTTP/S3")
+	fmt.Println("    ETag (or file modification time) where available and otherwise from the ACL file SHA-256 digest. The ACL is")
+	fmt.Println("    also reloaded at the --reconcile interval to correct any changes made directly to the controllers.")
+	fmt.Println()
+	fmt.Println("    The --sqs option long-polls an SQS queue that receives the S3 ObjectCreated notifications for the ACL file")
+	fmt.Println("    and loads the ACL as soon as a notification is received.")
+	fmt.Println()
+	fmt.Println("    A file:// URL for a directory watches the directory for ACL archives dropped into it by e.g. syncthing or")
+	fmt.Println("    rclone. Each archive is loaded once it has been unchanged for the --settle interval, after which it is")
+	fmt.Println("    moved to the 'processed' subdirectory (or to the 'rejected' subdirectory along with a .reason file if it")
+	fmt.Println("    could not be verified). Linux uses inotify to detect new files immediately - other platforms rely on the")
+	fmt.Println("    --interval polling.")
+	fmt.Println()
+	fmt.Println("    SIGHUP reloads the configuration file and reloads the ACL. SIGTERM (or SIGINT) stops watching once any")
+	fmt.Println("    load in progress has completed.")
+	fmt.Println()
+
+	helpOptions(cmd.FlagSet())
+	fmt.Println()
+}
+
+func (cmd *WatchACL) Execute(args ...interface{}) error {
+	options := args[0].(*Options)
+
+	cmd.config = options.Config
+	cmd.debug = options.Debug
+
+	// ... check parameters
+	if strings.TrimSpace(cmd.url) == "" {
+		return fmt.Errorf("watch-acl requires a URL for the authoritative ACL file in the command options")
+	}
+
+	uri, err := url.Parse(cmd.url)
+	if err != nil {
+		return fmt.Errorf("invalid ACL file URL '%s' (%w)", cmd.url, err)
+	}
+
+	if cmd.interval < 0 || (cmd.interval == 0 && cmd.sqs == "") {
+		return fmt.Errorf("invalid --interval (%v)", cmd.interval)
+	}
+
+	if cmd.sqs != "" {
+		if q, err := url.Parse(cmd.sqs); err != nil || (q.Scheme != "https" && q.Scheme != "http") {
+			return fmt.Errorf("invalid SQS queue URL '%s'", cmd.sqs)
+		}
+	}
+
+	if cmd.reconcile < 0 {
+		return fmt.Errorf("invalid --reconcile interval (%v)", cmd.reconcile)
+	}
+
+	if cmd.settle < 0 {
+		return fmt.Errorf("invalid --settle interval (%v)", cmd.settle)
+	}
+
+	dir, isDropDirectory := dropDirectory(cmd.url)
+	if isDropDirectory && cmd.sqs != "" {
+		return fmt.Errorf("--sqs is not supported for an ACL drop directory")
+	}
+
+	loader, u, devices, err := cmd.configure()
+	if err != nil {
+		return err
+	}
+
+	if !cmd.nolog {
+		events := eventlog.Ticker{Filename: cmd.logFile, MaxSize: cmd.logFileSize}
+		log.SetLogger(syslog.New(&events, "", syslog.Ldate|syslog.Ltime|syslog.LUTC))
+	} else {
+		log.SetLogger(syslog.New(os.Stdout, "ACL ", syslog.LstdFlags|syslog.LUTC|syslog.Lmsgprefix))
+	}
+
+	// ... locked?
+	lockFile := config.Lockfile{
+		File:   filepath.Join(cmd.workdir, "uhppoted-app-s3.lock"),
+		Remove: lockfile.RemoveLockfile,
+	}
+
+	if kraken, err := lockfile.MakeLockFile(lockFile); err != nil {
+		return err
+	} else {
+		defer func() {
+			infof("Removing lockfile '%v'", lockFile.File)
+			kraken.Release()
+		}()
+	}
+
+	if isDropDirectory {
+		return cmd.watchDirectory(loader, u, dir, devices)
+	}
+
+	return cmd.watch(loader, u, uri.String(), devices)
+}
+
+// Loads the configuration and returns a LoadACL with the watch-acl options for the load pipeline.
+func (cmd *WatchACL) configure() (*LoadACL, uhppote.IUHPPOTE, []uhppote.Device, error) {
+	conf := config.NewConfig()
+	pins, err := loadConfig(cmd.config, conf)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("WARN  Could not load configuration (%v)", err)
+	}
+
+	loader := LoadACL{
+		url:            cmd.url,
+		config:         cmd.config,
+		workdir:        cmd.workdir,
+		keysdir:        cmd.keysdir,
+		sitekey:        cmd.sitekey,
+		sitePassphrase: cmd.sitePassphrase,
+		credentials:    cmd.credentials,
+		profile:        cmd.profile,
+		region:         cmd.region,
+		logFile:        cmd.logFile,
+		logFileSize:    cmd.logFileSize,
+		template:       LoadACLCmd.template,
+		withPIN:        cmd.withPIN,
+		dryrun:         cmd.dryrun,
+		strict:         cmd.strict,
+		noreport:       cmd.noreport,
+		noverify:       cmd.noverify,
+		allowDowngrade: cmd.allowDowngrade,
+		pins:           pins,
+		nolog:          cmd.nolog,
+		debug:          cmd.debug,
+	}
+
+	if loader.credentials == "" {
+		loader.credentials = conf.AWS.Credentials
+	}
+
+	if loader.profile == "" {
+		loader.profile = conf.AWS.Profile
+	}
+
+	if loader.region == "" {
+		loader.region = conf.AWS.Region
+	}
+
+	// ... use the drop directory for the replay and downgrade protection state
+	if _, ok := dropDirectory(cmd.url); ok {
+		loader.source = cmd.url
+	}
+
+	u, devices := getDevices(conf, cmd.debug)
+
+	return &loader, u, devices, nil
+}
+
+// Loads the ACL and then reloads it whenever the ACL file version changes (and at the reconcile
+// interval) until terminated. Failed loads are logged and retried at the next interval.
+func (cmd *WatchACL) watch(loader *LoadACL, u uhppote.IUHPPOTE, uri string, devices []uhppote.Device) error {
+	log.Infof("Watching ACL at %v (interval:%v reconcile:%v)", uri, cmd.interval, cmd.reconcile)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	defer signal.Stop(signals)
+
+	var poll <-chan time.Time
+	if cmd.interval > 0 {
+		ticker := time.NewTicker(cmd.interval)
+		defer ticker.Stop()
+
+		poll = ticker.C
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// ... (re)starts the SQS listener with the current AWS credentials
+	notifications := make(chan string, 1)
+	stop := func() {}
+	listener := func() {
+		stop()
+		if cmd.sqs != "" {
+			var c context.Context
+
+			c, stop = context.WithCancel(ctx)
+			go listen(c, cmd.sqs, uri, loader.credentials, loader.profile, loader.region, notifications)
+		}
+	}
+
+	listener()
+
+	// ... watch the directory for file:// URLs to pick up changes without waiting for the next poll
+	var files <-chan string
+	if path, ok := strings.CutPrefix(uri, "file://"); ok {
+		if ch, err := watchFiles(ctx, filepath.Dir(path)); err != nil {
+			log.Debugf("Error watching %v (%v)", filepath.Dir(path), err)
+		} else {
+			files = ch
+		}
+	}
+
+	var reconcile <-chan time.Time
+	if cmd.reconcile > 0 {
+		ticker := time.NewTicker(cmd.reconcile)
+		defer ticker.Stop()
+
+		reconcile = ticker.C
+	}
+
+	last := ""
+	load := func() {
+		version, err := cmd.version(loader, uri)
+		if err != nil {
+			log.Warnf("Error checking ACL version (%v)", err)
+		}
+
+		if err := loader.execute(u, uri, devices); err != nil {
+			log.Warnf("Error loading ACL from %v (%v)", uri, err)
+		} else {
+			last = version
+		}
+	}
+
+	changed := func() {
+		if version, err := cmd.version(loader, uri); err != nil {
+			log.Warnf("Error checking ACL version (%v)", err)
+		} else if version != last {
+			log.Infof("ACL at %v has changed (%v)", uri, version)
+			load()
+		}
+	}
+
+	// ... a changed file:// ACL is only loaded once the size and modification time are unchanged for
+	//     the settle interval, re-checking after the settle interval
+	pending := map[string]observed{}
+	var recheck <-chan time.Time
+
+	check := func() {
+		path := strings.TrimPrefix(uri, "file://")
+
+		recheck = nil
+		if settled(pending, path, time.Now(), cmd.settle) {
+			delete(pending, path)
+			changed()
+		} else if _, ok := pending[path]; ok {
+			recheck = time.After(cmd.settle)
+		}
+	}
+
+	load()
+
+	for {
+		select {
+		case <-poll:
+			if strings.HasPrefix(uri, "file://") {
+				check()
+			} else {
+				changed()
+			}
+
+		case <-recheck:
+			check()
+
+		case name, ok := <-files:
+			if !ok {
+				files = nil
+			} else if name == filepath.Base(strings.TrimPrefix(uri, "file://")) {
+				check()
+			}
+
+		case object := <-notifications:
+			log.Infof("Received S3 notification for %v", object)
+			load()
+
+		case <-reconcile:
+			log.Infof("Reconciling controllers with ACL at %v", uri)
+			load()
+
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Infof("Stopped watching ACL at %v (%v)", uri, sig)
+				return nil
+			}
+
+			log.Infof("Reloading configuration from %v", cmd.config)
+			if l, v, d, err := cmd.configure(); err != nil {
+				log.Warnf("Error reloading configuration (%v)", err)
+			} else {
+				loader, u, devices = l, v, d
+				listener()
+			}
+
+			load()
+		}
+	}
+}
+
+// Returns the ETag (or equivalent) of the ACL file, falling back to the SHA-256 digest of the ACL file
+// if the source does not support HEAD requests (e.g. pre-signed S3 GET URLs).
+func (cmd *WatchACL) version(loader *LoadACL, uri string) (string, error) {
+	head := headHTTP
+	fetch := loader.fetchHTTP
+	if strings.HasPrefix(uri, "s3://") {
+		head = func(url string) (string, error) {
+			return headS3(url, loader.credentials, loader.profile, loader.region)
+		}
+		fetch = loader.fetchS3
+	} else if strings.HasPrefix(uri, "file://") {
+		head = headFile
+		fetch = loader.fetchFile
+	}
+
+	if version, err := head(uri); err == nil && version != "" {
+		return version, nil
+	} else if err != nil {
+		log.Debugf("HEAD %v failed (%v) - using ACL file SHA-256 digest", uri, err)
+	}
+
+	b, err := fetch(uri)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(b)
+
+	return "SHA-256:" + hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,276 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/uhppoted/uhppote-core/uhppote"
+
+	"github.com/uhppoted/uhppoted-app-s3/log"
+)
+
+const (
+	PROCESSED = "processed"
+	REJECTED  = "rejected"
+)
+
+// Returns the directory path if the URL is a file:// URL for a directory, i.e. a drop directory for
+// ACL archives delivered by e.g. syncthing or rclone.
+func dropDirectory(uri string) (string, bool) {
+	if path, ok := strings.CutPrefix(uri, "file://"); ok {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return filepath.Clean(path), true
+		}
+	}
+
+	return "", false
+}
+
+// Loads the ACL archives dropped into the directory, in the order in which they arrived, until
+// terminated. Each archive is verified once it has settled and moved to the 'processed' subdirectory
+// once it has been loaded or to the 'rejected' subdirectory (with a .reason file) if it is not valid.
+// Archives that are valid but could not be loaded are left in place and retried at the next interval.
+func (cmd *WatchACL) watchDirectory(loader *LoadACL, u uhppote.IUHPPOTE, dir string, devices []uhppote.Device) error {
+	log.Infof("Watching ACL drop directory %v (interval:%v reconcile:%v)", dir, cmd.interval, cmd.reconcile)
+
+	for _, subdir := range []string{PROCESSED, REJECTED} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0750); err != nil {
+			return err
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	defer signal.Stop(signals)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	files, err := watchFiles(ctx, dir)
+	if err != nil {
+		log.Warnf("Error watching %v (%v) - polling for ACL archives", dir, err)
+	}
+
+	var poll <-chan time.Time
+	if cmd.interval > 0 {
+		ticker := time.NewTicker(cmd.interval)
+		defer ticker.Stop()
+
+		poll = ticker.C
+	}
+
+	var reconcile <-chan time.Time
+	if cmd.reconcile > 0 {
+		ticker := time.NewTicker(cmd.reconcile)
+		defer ticker.Stop()
+
+		reconcile = ticker.C
+	}
+
+	latest := latestArchive(filepath.Join(dir, PROCESSED))
+
+	process := func(path string) {
+		uri := "file://" + path
+
+		if err := loader.check(uri, devices); err != nil {
+			log.Warnf("Rejected ACL archive %v (%v)", path, err)
+			if err := reject(path, err); err != nil {
+				log.Warnf("Error moving %v to %v (%v)", path, REJECTED, err)
+			}
+
+			return
+		}
+
+		if err := loader.execute(u, uri, devices); err != nil {
+			log.Warnf("Error loading ACL from %v (%v) - retrying at next interval", uri, err)
+			return
+		}
+
+		processed := filepath.Join(dir, PROCESSED, filepath.Base(path))
+		if err := os.Rename(path, processed); err != nil {
+			log.Warnf("Error moving %v to %v (%v)", path, PROCESSED, err)
+		} else {
+			latest = processed
+		}
+	}
+
+	// ... archives are processed once the size and modification time are unchanged for the settle
+	//     interval, re-checking unsettled archives after the settle interval
+	pending := map[string]observed{}
+	var recheck <-chan time.Time
+
+	scan := func() {
+		now := time.Now()
+		found := map[string]bool{}
+
+		for _, path := range archives(dir) {
+			found[path] = true
+			if settled(pending, path, now, cmd.settle) {
+				delete(pending, path)
+				process(path)
+			}
+		}
+
+		for path := range pending {
+			if !found[path] {
+				delete(pending, path)
+			}
+		}
+
+		recheck = nil
+		if len(pending) > 0 {
+			recheck = time.After(cmd.settle)
+		}
+	}
+
+	scan()
+
+	for {
+		select {
+		case <-poll:
+			scan()
+
+		case <-recheck:
+			scan()
+
+		case name, ok := <-files:
+			if !ok {
+				files = nil
+			} else if isArchive(name) {
+				scan()
+			}
+
+		case <-reconcile:
+			if latest != "" {
+				log.Infof("Reconciling controllers with ACL %v", latest)
+				if err := loader.execute(u, "file://"+latest, devices); err != nil {
+					log.Warnf("Error loading ACL from %v (%v)", latest, err)
+				}
+			}
+
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Infof("Stopped watching ACL drop directory %v (%v)", dir, sig)
+				return nil
+			}
+
+			log.Infof("Reloading configuration from %v", cmd.config)
+			if l, v, d, err := cmd.configure(); err != nil {
+				log.Warnf("Error reloading configuration (%v)", err)
+			} else {
+				loader, u, devices = l, v, d
+			}
+
+			scan()
+		}
+	}
+}
+
+// Size and modification time of a dropped file when first seen (or last seen to change).
+type observed struct {
+	size     int64
+	modified time.Time
+	seen     time.Time
+}
+
+// Returns true if the file size and modification time have been unchanged for the settle interval
+// i.e. the file has been completely written. Files that are new or have changed since the previous
+// check are (re)recorded as pending.
+func settled(pending map[string]observed, path string, now time.Time, settle time.Duration) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	if v, ok := pending[path]; ok && v.size == info.Size() && v.modified.Equal(info.ModTime()) {
+		return now.Sub(v.seen) >= settle
+	}
+
+	pending[path] = observed{
+		size:     info.Size(),
+		modified: info.ModTime(),
+		seen:     now,
+	}
+
+	return settle <= 0
+}
+
+// Returns the ACL archives in the directory, oldest first. Hidden files are ignored, along with the
+// partially transferred files created by syncthing and rclone (which have a different extension).
+func archives(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Warnf("Error reading %v (%v)", dir, err)
+		return nil
+	}
+
+	type file struct {
+		path     string
+		modified time.Time
+	}
+
+	list := []file{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isArchive(entry.Name()) {
+			if info, err := entry.Info(); err == nil {
+				list = append(list, file{filepath.Join(dir, entry.Name()), info.ModTime()})
+			}
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].modified.Equal(list[j].modified) {
+			return list[i].path < list[j].path
+		}
+
+		return list[i].modified.Before(list[j].modified)
+	})
+
+	paths := []string{}
+	for _, f := range list {
+		paths = append(paths, f.path)
+	}
+
+	return paths
+}
+
+func latestArchive(dir string) string {
+	if list := archives(dir); len(list) > 0 {
+		return list[len(list)-1]
+	}
+
+	return ""
+}
+
+func isArchive(name string) bool {
+	return !strings.HasPrefix(name, ".") && (strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".zip"))
+}
+
+// Moves a rejected archive to the 'rejected' subdirectory along with a .reason file. The rejected
+// archive name includes a timestamp so that rejected archives with the same name are not overwritten.
+func reject(path string, reason error) error {
+	now := time.Now()
+	name := filepath.Base(path)
+	ext := ".zip"
+	if strings.HasSuffix(name, ".tar.gz") {
+		ext = ".tar.gz"
+	}
+
+	timestamped := fmt.Sprintf("%v-%v%v", strings.TrimSuffix(name, ext), now.Format("2006-01-02T150405.000"), ext)
+	rejected := filepath.Join(filepath.Dir(path), REJECTED, timestamped)
+	text := fmt.Sprintf("%v  %v\n", now.Format(time.RFC3339), reason)
+
+	if err := os.WriteFile(rejected+".reason", []byte(text), 0640); err != nil {
+		return err
+	}
+
+	return os.Rename(path, rejected)
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/uhppoted/uhppoted-lib/acl"
+
+	"github.com/uhppoted/uhppoted-app-s3/xlsx"
+)
+
+const XLSX_SHEET = "ACL"
+
+// Parses an XLSX ACL worksheet with the same header conventions as the TSV format. Dates stored as
+// spreadsheet date values are converted to yyyy-mm-dd and TRUE/FALSE door permissions to Y/N.
+func parseXLSX(b []byte, sheet string) (*acl.Table, error) {
+	workbook, err := xlsx.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var ws *xlsx.Sheet
+	for i := range workbook.Sheets {
+		if sheet == "" || workbook.Sheets[i].Name == sheet {
+			ws = &workbook.Sheets[i]
+			break
+		}
+	}
+
+	if ws == nil && sheet == "" {
+		return nil, fmt.Errorf("XLSX ACL file has no worksheets")
+	} else if ws == nil {
+		return nil, fmt.Errorf("XLSX ACL file has no '%v' worksheet", sheet)
+	}
+
+	rows := [][]string{}
+	for _, row := range ws.Rows {
+		if strings.TrimSpace(strings.Join(row, "")) != "" {
+			rows = append(rows, row)
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("XLSX worksheet '%v' is empty", ws.Name)
+	}
+
+	header := rows[0]
+	for len(header) > 0 && strings.TrimSpace(header[len(header)-1]) == "" {
+		header = header[:len(header)-1]
+	}
+
+	table := acl.Table{
+		Header:  header,
+		Records: [][]string{},
+	}
+
+	for _, row := range rows[1:] {
+		record := make([]string, len(header))
+		copy(record, row)
+
+		for i, h := range header {
+			value := strings.TrimSpace(record[i])
+
+			if _, ok := holderField(h); ok {
+				continue
+			}
+
+			switch normalise(h) {
+			case "cardnumber", "pin", "groups":
+
+			case "from", "to":
+				if serial, err := strconv.ParseFloat(value, 64); err == nil {
+					record[i] = xlsx.Date(serial, workbook.Date1904).Format("2006-01-02")
+				}
+
+			default:
+				if value == "TRUE" {
+					record[i] = "Y"
+				} else if value == "FALSE" {
+					record[i] = "N"
+				}
+			}
+		}
+
+		table.Records = append(table.Records, record)
+	}
+
+	return &table, nil
+}
+
+// Converts an ACL table to an XLSX ACL file with a single worksheet (named 'ACL' by default).
+func makeXLSX(table *acl.Table, sheet string) ([]byte, error) {
+	if sheet == "" {
+		sheet = XLSX_SHEET
+	}
+
+	var b bytes.Buffer
+
+	rows := append([][]string{table.Header}, table.Records...)
+	if err := xlsx.Write(sheet, rows, &b); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
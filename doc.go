@@ -14,5 +14,13 @@ uhppoted-app-s3 supports the following commands:
   - load-acl, to download an ACL from a file to a set of access controllers
   - store-acl, to retrieve the ACL from a set of controllers and save it as a file
   - compare-acl, to compare an ACL from a file with the cards and permissons on a set of access controllers
+  - keygen, to generate an RSA key pair for signing ACL files
+  - sign-acl, to sign an ACL file and package it as a .tar.gz or .zip archive
+  - verify-acl, to verify the signature of an ACL archive
+  - publish-acl, to validate, sign and upload an ACL file
+  - validate-acl, to validate an ACL archive without updating the controllers
+  - convert-acl, to convert an ACL file between the TSV, CSV, JSON and XLSX formats
+  - diff-acl, to compare two ACL archives without contacting the controllers
+  - watch-acl, to load an ACL to a set of access controllers whenever it changes
 */
 package s3
@@ -4,8 +4,10 @@ go 1.24
 
 require (
 	github.com/aws/aws-sdk-go v1.55.6
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/uhppoted/uhppote-core v0.8.11-0.20250331165159-e04fd7de7eab
 	github.com/uhppoted/uhppoted-lib v0.8.11-0.20250331180353-7ccb6f69d17e
+	golang.org/x/crypto v0.36.0
 	golang.org/x/sys v0.31.0
 )
 
@@ -0,0 +1,334 @@
+// Package xlsx implements the minimal subset of the Office Open XML spreadsheet format required to
+// read and write ACL worksheets i.e. cell values only, without styles, formulas or merged cells.
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Worksheet limits, i.e. the Excel maximum number of rows and columns (XFD) and an upper bound on the
+// number of cells in a worksheet, so that malformed cell references cannot exhaust memory.
+const (
+	maxRows    = 1048576
+	maxColumns = 16384
+	maxCells   = 1048576
+)
+
+type Workbook struct {
+	Sheets   []Sheet
+	Date1904 bool
+}
+
+type Sheet struct {
+	Name string
+	Rows [][]string
+}
+
+type workbook struct {
+	WorkbookPr struct {
+		Date1904 string `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type relationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type sharedStrings struct {
+	Items []richText `xml:"si"`
+}
+
+type richText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t richText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+
+	var s strings.Builder
+	for _, r := range t.Runs {
+		s.WriteString(r.Text)
+	}
+
+	return s.String()
+}
+
+type worksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline richText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// Read returns the cell values of all the worksheets in an XLSX workbook. Shared and inline strings
+// are returned as text, booleans as TRUE or FALSE and numbers (including dates) as the stored value.
+func Read(b []byte) (*Workbook, error) {
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range r.File {
+		files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+
+	var wb workbook
+	var rels relationships
+	var shared sharedStrings
+
+	if err := unmarshal(files, "xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshal(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := unmarshal(files, "xl/sharedStrings.xml", &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := map[string]string{}
+	for _, r := range rels.Relationships {
+		if strings.HasPrefix(r.Target, "/") {
+			targets[r.ID] = strings.TrimPrefix(r.Target, "/")
+		} else {
+			targets[r.ID] = path.Join("xl", r.Target)
+		}
+	}
+
+	workbook := Workbook{
+		Sheets:   []Sheet{},
+		Date1904: wb.WorkbookPr.Date1904 == "1" || wb.WorkbookPr.Date1904 == "true",
+	}
+
+	for _, s := range wb.Sheets {
+		target, ok := targets[s.ID]
+		if !ok {
+			return nil, fmt.Errorf("missing worksheet '%v'", s.Name)
+		}
+
+		var ws worksheet
+		if err := unmarshal(files, target, &ws); err != nil {
+			return nil, err
+		}
+
+		if len(ws.Rows) > maxRows {
+			return nil, fmt.Errorf("worksheet '%v' exceeds %v rows", s.Name, maxRows)
+		}
+
+		sheet := Sheet{
+			Name: s.Name,
+			Rows: [][]string{},
+		}
+
+		cells := 0
+
+		for _, row := range ws.Rows {
+			record := []string{}
+
+			for _, c := range row.Cells {
+				col := len(record)
+				if c.Ref != "" {
+					if col, err = column(c.Ref); err != nil {
+						return nil, err
+					}
+				}
+
+				if col >= maxColumns {
+					return nil, fmt.Errorf("invalid cell reference '%v' (exceeds %v columns)", c.Ref, maxColumns)
+				}
+
+				if n := col + 1 - len(record); n > 0 {
+					if cells += n; cells > maxCells {
+						return nil, fmt.Errorf("worksheet '%v' exceeds %v cells", s.Name, maxCells)
+					}
+
+					record = append(record, make([]string, n)...)
+				}
+
+				switch c.Type {
+				case "s":
+					if ix, err := strconv.Atoi(c.Value); err != nil || ix < 0 || ix >= len(shared.Items) {
+						return nil, fmt.Errorf("invalid shared string index '%v' in cell %v", c.Value, c.Ref)
+					} else {
+						record[col] = shared.Items[ix].String()
+					}
+
+				case "inlineStr":
+					record[col] = c.Inline.String()
+
+				case "b":
+					if c.Value == "1" {
+						record[col] = "TRUE"
+					} else {
+						record[col] = "FALSE"
+					}
+
+				default:
+					record[col] = c.Value
+				}
+			}
+
+			sheet.Rows = append(sheet.Rows, record)
+		}
+
+		workbook.Sheets = append(workbook.Sheets, sheet)
+	}
+
+	return &workbook, nil
+}
+
+// Write creates a single worksheet XLSX workbook with the cell values stored as inline strings.
+func Write(sheet string, rows [][]string, w io.Writer) error {
+	z := zip.NewWriter(w)
+
+	var s strings.Builder
+
+	s.WriteString(xml.Header)
+	s.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(&s, `<row r="%d">`, i+1)
+		for j, v := range row {
+			fmt.Fprintf(&s, `<c r="%v%d" t="inlineStr"><is><t xml:space="preserve">`, columnName(j), i+1)
+			if err := xml.EscapeText(&s, []byte(v)); err != nil {
+				return err
+			}
+			s.WriteString(`</t></is></c>`)
+		}
+		s.WriteString(`</row>`)
+	}
+	s.WriteString(`</sheetData></worksheet>`)
+
+	var name strings.Builder
+	if err := xml.EscapeText(&name, []byte(sheet)); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xml.Header +
+			`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xml.Header +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header +
+			`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="` + name.String() + `" sheetId="1" r:id="rId1"/></sheets>` +
+			`</workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+		{"xl/worksheets/sheet1.xml", s.String()},
+	}
+
+	for _, f := range files {
+		if w, err := z.Create(f.name); err != nil {
+			return err
+		} else if _, err := w.Write([]byte(f.content)); err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+// Date converts a spreadsheet date serial number to a date.
+func Date(serial float64, date1904 bool) time.Time {
+	epoch := time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	days := math.Floor(serial)
+
+	return epoch.AddDate(0, 0, int(days))
+}
+
+func unmarshal(files map[string]*zip.File, name string, v any) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("invalid XLSX file (missing %v)", name)
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("invalid XLSX file (%v: %w)", name, err)
+	}
+
+	return nil
+}
+
+// Returns the zero-based column index for a cell reference e.g. C5. Columns beyond XFD are rejected.
+func column(ref string) (int, error) {
+	col := 0
+	for i, ch := range ref {
+		if ch >= 'A' && ch <= 'Z' {
+			if col = col*26 + int(ch-'A'+1); col > maxColumns {
+				return 0, fmt.Errorf("invalid cell reference '%v' (exceeds %v columns)", ref, maxColumns)
+			}
+		} else if i > 0 && ch >= '0' && ch <= '9' {
+			break
+		} else {
+			return 0, fmt.Errorf("invalid cell reference '%v'", ref)
+		}
+	}
+
+	if col == 0 {
+		return 0, fmt.Errorf("invalid cell reference '%v'", ref)
+	}
+
+	return col - 1, nil
+}
+
+func columnName(col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+
+	return name
+}
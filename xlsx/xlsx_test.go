@@ -0,0 +1,149 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	rows := [][]string{
+		{"Card Number", "From", "To", "Front Door"},
+		{"10058400", "2026-01-01", "2026-12-31", "Y"},
+	}
+
+	var b bytes.Buffer
+	if err := Write("ACL", rows, &b); err != nil {
+		t.Fatalf("error writing workbook (%v)", err)
+	}
+
+	wb, err := Read(b.Bytes())
+	if err != nil {
+		t.Fatalf("error reading workbook (%v)", err)
+	}
+
+	if len(wb.Sheets) != 1 || wb.Sheets[0].Name != "ACL" {
+		t.Fatalf("incorrect worksheets - expected:[ACL], got:%v", wb.Sheets)
+	}
+
+	if !reflect.DeepEqual(wb.Sheets[0].Rows, rows) {
+		t.Errorf("incorrect rows\n   expected:%v\n   got:     %v", rows, wb.Sheets[0].Rows)
+	}
+}
+
+func TestReadSparseRow(t *testing.T) {
+	wb, err := Read(makeWorkbook(t, `<row><c r="A1" t="inlineStr"><is><t>A</t></is></c><c r="C1"><v>3</v></c></row>`))
+	if err != nil {
+		t.Fatalf("error reading workbook (%v)", err)
+	}
+
+	expected := [][]string{{"A", "", "3"}}
+	if !reflect.DeepEqual(wb.Sheets[0].Rows, expected) {
+		t.Errorf("incorrect rows\n   expected:%v\n   got:     %v", expected, wb.Sheets[0].Rows)
+	}
+}
+
+func TestReadMalformedReference(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{"ZZZZZZZZZZZZZZ1", "exceeds 16384 columns"},
+		{"ZZZZZZ1", "exceeds 16384 columns"},
+		{"XFE1", "exceeds 16384 columns"},
+		{"1A", "invalid cell reference"},
+		{"a1", "invalid cell reference"},
+		{"A-1", "invalid cell reference"},
+		{"$A$1", "invalid cell reference"},
+	}
+
+	for _, test := range tests {
+		_, err := Read(makeWorkbook(t, `<row><c r="`+test.ref+`"><v>1</v></c></row>`))
+		if err == nil {
+			t.Errorf("expected error for cell reference '%v'", test.ref)
+		} else if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("incorrect error for cell reference '%v' - expected:%v, got:%v", test.ref, test.expected, err)
+		}
+	}
+}
+
+func TestReadMaxColumn(t *testing.T) {
+	wb, err := Read(makeWorkbook(t, `<row><c r="XFD1"><v>1</v></c></row>`))
+	if err != nil {
+		t.Fatalf("error reading workbook (%v)", err)
+	}
+
+	if row := wb.Sheets[0].Rows[0]; len(row) != maxColumns || row[maxColumns-1] != "1" {
+		t.Errorf("incorrect row - expected %v columns, got %v", maxColumns, len(row))
+	}
+}
+
+func TestReadTooManyCells(t *testing.T) {
+	row := `<row><c r="XFD1"><v>1</v></c></row>`
+	rows := strings.Repeat(row, maxCells/maxColumns+1)
+
+	if _, err := Read(makeWorkbook(t, rows)); err == nil {
+		t.Errorf("expected error for worksheet with more than %v cells", maxCells)
+	} else if !strings.Contains(err.Error(), "cells") {
+		t.Errorf("incorrect error - expected:exceeds %v cells, got:%v", maxCells, err)
+	}
+}
+
+func TestColumn(t *testing.T) {
+	tests := map[string]int{
+		"A1":       0,
+		"Z9":       25,
+		"AA10":     26,
+		"XFD1":     16383,
+		"B1048576": 1,
+	}
+
+	for ref, expected := range tests {
+		if col, err := column(ref); err != nil {
+			t.Errorf("unexpected error for '%v' (%v)", ref, err)
+		} else if col != expected {
+			t.Errorf("incorrect column for '%v' - expected:%v, got:%v", ref, expected, col)
+		}
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for _, col := range []int{0, 25, 26, 701, 702, 16383} {
+		if v, err := column(columnName(col) + "1"); err != nil || v != col {
+			t.Errorf("column name for %v (%v) does not round trip (%v, %v)", col, columnName(col), v, err)
+		}
+	}
+}
+
+// Creates a single worksheet XLSX workbook with the sheet data.
+func makeWorkbook(t *testing.T, rows string) []byte {
+	t.Helper()
+
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="ACL" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+			rows + `</sheetData></worksheet>`,
+	}
+
+	var b bytes.Buffer
+
+	z := zip.NewWriter(&b)
+	for name, content := range files {
+		if w, err := z.Create(name); err != nil {
+			t.Fatalf("error creating %v (%v)", name, err)
+		} else if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("error writing %v (%v)", name, err)
+		}
+	}
+
+	if err := z.Close(); err != nil {
+		t.Fatalf("error creating workbook (%v)", err)
+	}
+
+	return b.Bytes()
+}